@@ -10,17 +10,51 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/dmytrochumakov/httpfromtcp/internal/accesslog"
+	"github.com/dmytrochumakov/httpfromtcp/internal/compression"
 	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/metrics"
 	"github.com/dmytrochumakov/httpfromtcp/internal/request"
 	"github.com/dmytrochumakov/httpfromtcp/internal/response"
 	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+	"github.com/dmytrochumakov/httpfromtcp/internal/tracing"
 )
 
 const port = 42069
 
+var (
+	registry      = metrics.NewRegistry()
+	serverMetrics = metrics.NewServerMetrics(registry)
+	tracer        = tracing.NewTracer(newSpanExporter(), tracing.Options{})
+)
+
+// newSpanExporter sends spans to the collector in OTEL_EXPORTER_OTLP_ENDPOINT
+// if it is set and prints them otherwise.
+func newSpanExporter() tracing.Exporter {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return tracing.NewOTLPExporter(tracing.OTLPOptions{
+			Endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+			ServiceName: "httpserver",
+		})
+	}
+	return tracing.NewJSONExporter(os.Stdout)
+}
+
 func main() {
-	server, err := server.Serve(port, handler)
+	defer tracer.Shutdown()
+	traced := tracing.Trace(tracer, compression.Compress(compression.Options{}, handler))
+	logged := accesslog.Log(accesslog.Options{Format: accesslog.CombinedFormat}, traced)
+	server, err := server.Serve(port, logged,
+		server.WithObserver(serverMetrics),
+		server.WithLimits(server.Limits{
+			MaxConns:      10000,
+			MaxConnsPerIP: 100,
+			HeaderTimeout: 10 * time.Second,
+			MinBodyRate:   240,
+		}),
+	)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -33,40 +67,71 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
+// Every route is instrumented under a fixed label, so the metrics don't
+// grow with whatever targets clients send.
+var (
+	metricsRoute = serverMetrics.Instrument("/metrics", registry.Handler())
+	route400     = serverMetrics.Instrument("/yourproblem", handler400)
+	route500     = serverMetrics.Instrument("/myproblem", handler500)
+	httpbinRoute = serverMetrics.Instrument("/httpbin/*", proxyHandler)
+	videoRoute   = serverMetrics.Instrument("/video", handlerVideo)
+	route200     = serverMetrics.Instrument("other", handler200)
+)
+
 func handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.RequestTarget == "/metrics" {
+		metricsRoute(w, req)
+		return
+	}
+
 	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
+		route400(w, req)
 		return
 	}
 
 	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
+		route500(w, req)
 		return
 	}
 
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		proxyHandler(w, req)
+		httpbinRoute(w, req)
 		return
 	}
 
 	if req.RequestLine.RequestTarget == "/video" {
-		handlerVideo(w, req)
+		videoRoute(w, req)
 		return
 	}
 
-	handler200(w, req)
+	route200(w, req)
 }
 
 func proxyHandler(w *response.Writer, req *request.Request) {
 	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
 	url := "https://httpbin.org/" + target
-	fmt.Println("Proxying to", url)
-	resp, err := http.Get(url)
+
+	span := tracer.Start("GET", tracing.SpanKindClient, tracing.SpanFromRequest(req).Context())
+	span.SetAttribute("url.full", url)
+	defer span.End()
+
+	outReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		handler500(w, req)
+		return
+	}
+	outReq.Header.Set("traceparent", span.Context().Traceparent())
+	if tracestate := span.Context().TraceState; tracestate != "" {
+		outReq.Header.Set("tracestate", tracestate)
+	}
+	resp, err := http.DefaultClient.Do(outReq)
 	if err != nil {
+		span.SetStatus(tracing.StatusError, err.Error())
 		handler500(w, req)
 		return
 	}
 	defer resp.Body.Close()
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
 
 	w.WriteStatusLine(response.OK)
 	h := response.GetDefaultHeaders(0)
@@ -81,11 +146,10 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 	buffer := make([]byte, maxChunkSize)
 	for {
 		n, err := resp.Body.Read(buffer)
-		fmt.Println("Read", n, "bytes")
 		if n > 0 {
 			_, err = w.WriteChunkedBody(buffer[:n])
 			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
+				log.Printf("Error writing chunked body: %v", err)
 				break
 			}
 			fullBody = append(fullBody, buffer[:n]...)
@@ -94,13 +158,13 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 			break
 		}
 		if err != nil {
-			fmt.Println("Error reading response body:", err)
+			log.Printf("Error reading response body: %v", err)
 			break
 		}
 	}
 	_, err = w.WriteChunkedBodyDone()
 	if err != nil {
-		fmt.Println("Error writing chunked body done:", err)
+		log.Printf("Error writing chunked body done: %v", err)
 	}
 	trailers := headers.NewHeaders()
 	sha256 := fmt.Sprintf("%x", sha256.Sum256(fullBody))
@@ -108,9 +172,8 @@ func proxyHandler(w *response.Writer, req *request.Request) {
 	trailers.Override("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
 	err = w.WriteTrailers(trailers)
 	if err != nil {
-		fmt.Println("Error writing trailers:", err)
+		log.Printf("Error writing trailers: %v", err)
 	}
-	fmt.Println("Wrote trailers")
 }
 
 func handlerVideo(w *response.Writer, req *request.Request) {
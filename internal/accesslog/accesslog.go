@@ -0,0 +1,167 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+type Format int
+
+const (
+	// CommonFormat is the Common Log Format:
+	//   host - - [time] "request line" status bytes
+	CommonFormat Format = iota
+	// CombinedFormat adds the quoted referer and user agent to CommonFormat.
+	CombinedFormat
+	// JSONFormat logs one slog record per request with a JSON handler.
+	JSONFormat
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+type Options struct {
+	Format Format
+	// Output receives the log lines, os.Stdout by default.
+	Output io.Writer
+	// Logger overrides Output for JSONFormat, so entries can share a
+	// logger and its attributes with the rest of the application.
+	Logger *slog.Logger
+}
+
+// Entry is what gets logged about one request.
+type Entry struct {
+	RemoteAddr string
+	Time       time.Time
+	Method     string
+	Target     string
+	Version    string
+	Status     response.StatusCode
+	Bytes      int
+	Duration   time.Duration
+	UserAgent  string
+	Referer    string
+}
+
+// Log returns a handler that calls next and logs the request once it has
+// returned.
+func Log(opts Options, next server.Handler) server.Handler {
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+	l := &logger{opts: opts}
+	if opts.Format == JSONFormat {
+		l.slog = opts.Logger
+		if l.slog == nil {
+			l.slog = slog.New(slog.NewJSONHandler(opts.Output, nil))
+		}
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+
+		entry := Entry{
+			RemoteAddr: "-",
+			Time:       start,
+			Method:     req.RequestLine.Method,
+			Target:     req.RequestLine.RequestTarget,
+			Version:    req.RequestLine.HttpVersion,
+			Status:     w.StatusCode(),
+			Bytes:      w.BodyBytes(),
+			Duration:   time.Since(start),
+		}
+		if req.RemoteAddr != "" {
+			entry.RemoteAddr = hostOf(req.RemoteAddr)
+		}
+		entry.UserAgent, _ = req.Headers.Get("User-Agent")
+		entry.Referer, _ = req.Headers.Get("Referer")
+		l.log(req.Context(), entry)
+	}
+}
+
+type logger struct {
+	opts Options
+	slog *slog.Logger
+
+	mu sync.Mutex
+}
+
+func (l *logger) log(ctx context.Context, e Entry) {
+	switch l.opts.Format {
+	case JSONFormat:
+		l.slog.LogAttrs(ctx, slog.LevelInfo, "request",
+			slog.String("remote_addr", e.RemoteAddr),
+			slog.String("method", e.Method),
+			slog.String("target", e.Target),
+			slog.String("version", "HTTP/"+e.Version),
+			slog.Int("status", int(e.Status)),
+			slog.Int("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("user_agent", e.UserAgent),
+			slog.String("referer", e.Referer),
+		)
+	case CombinedFormat:
+		l.write(fmt.Sprintf("%s %s %s\n", common(e), quote(e.Referer), quote(e.UserAgent)))
+	default:
+		l.write(common(e) + "\n")
+	}
+}
+
+func (l *logger) write(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.opts.Output, line)
+}
+
+func common(e Entry) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.Itoa(e.Bytes)
+	}
+	requestLine := fmt.Sprintf("%s %s HTTP/%s", e.Method, e.Target, e.Version)
+	return fmt.Sprintf("%s - - [%s] %s %d %s",
+		e.RemoteAddr, e.Time.Format(clfTimeLayout), quote(requestLine), e.Status, bytes)
+}
+
+// quote wraps s in double quotes, escaping what would break the line apart,
+// or returns "-" for an empty value.
+func quote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,173 @@
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+// syncBuffer lets the test read what the server goroutine logged.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func hello(w *response.Writer, req *request.Request) {
+	body := "hello"
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+// serveOne runs handler behind a server and sends it one request.
+func serveOne(t *testing.T, handler server.Handler, raw string) {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	port := s.Addr().(*net.TCPAddr).Port
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	// the line is logged after the response went out, wait for the server
+	// to close the connection once the handler returned
+	io.Copy(io.Discard, br)
+}
+
+const testRequest = "GET /greet?x=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test \"agent\"\r\nReferer: http://example.com/\r\nConnection: close\r\n\r\n"
+
+func TestLogFormats(t *testing.T) {
+	// Test: Common Log Format
+	var out syncBuffer
+	serveOne(t, Log(Options{Output: &out}, hello), testRequest)
+	assert.Regexp(t, regexp.MustCompile(`^127\.0\.0\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /greet\?x=1 HTTP/1\.1" 200 5\n$`), out.String())
+
+	// Test: Combined Log Format escapes quotes
+	out = syncBuffer{}
+	serveOne(t, Log(Options{Format: CombinedFormat, Output: &out}, hello), testRequest)
+	assert.Regexp(t, regexp.MustCompile(`" 200 5 "http://example\.com/" "test \\"agent\\""\n$`), out.String())
+
+	// Test: JSON
+	out = syncBuffer{}
+	serveOne(t, Log(Options{Format: JSONFormat, Output: &out}, hello), testRequest)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out.String()), &entry))
+	assert.Equal(t, "127.0.0.1", entry["remote_addr"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/greet?x=1", entry["target"])
+	assert.Equal(t, "HTTP/1.1", entry["version"])
+	assert.EqualValues(t, 200, entry["status"])
+	assert.EqualValues(t, 5, entry["bytes"])
+	assert.Equal(t, `test "agent"`, entry["user_agent"])
+	assert.Equal(t, "http://example.com/", entry["referer"])
+	assert.Contains(t, entry, "duration")
+}
+
+func TestLogChunkedBytes(t *testing.T) {
+	// Test: Chunked bodies count payload bytes only
+	var out syncBuffer
+	chunked := func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("abc"))
+		w.WriteChunkedBody([]byte("defg"))
+	}
+	serveOne(t, Log(Options{Output: &out}, chunked), testRequest)
+	assert.Contains(t, out.String(), `" 200 7`)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, RotateOptions{MaxSize: 10, MaxBackups: 2})
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := io.WriteString(f, line)
+		require.NoError(t, err)
+	}
+
+	// Test: Oldest backups are dropped
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// Test: Manual rotation
+	require.NoError(t, f.Rotate())
+	assert.Equal(t, "", read(path))
+	assert.Equal(t, "fourth\n", read(path+".1"))
+
+	// Test: Writes after Close fail
+	require.NoError(t, f.Close())
+	_, err = io.WriteString(f, "late\n")
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFileRenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := NewRotatingFile(path, RotateOptions{MaxSize: 10, MaxBackups: 1})
+	require.NoError(t, err)
+	defer f.Close()
+
+	// a non-empty directory where the backup goes can't be replaced
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "keep"), 0o755))
+
+	// Test: Rotation errors are returned
+	_, err = io.WriteString(f, "first\n")
+	require.NoError(t, err)
+	assert.Error(t, f.Rotate())
+
+	// Test: Writes continue to the current file
+	n, err := io.WriteString(f, "second\n")
+	assert.Error(t, err)
+	assert.Equal(t, len("second\n"), n)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n", string(data))
+}
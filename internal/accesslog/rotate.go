@@ -0,0 +1,141 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type RotateOptions struct {
+	// MaxSize is the size in bytes a file may reach before it is rotated,
+	// 100MiB by default.
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept, the oldest are
+	// removed. Zero keeps all of them.
+	MaxBackups int
+}
+
+const defaultMaxSize = 100 << 20
+
+// RotatingFile is an append-only log file that is renamed to path.1 once it
+// grows past MaxSize, shifting older backups to path.2, path.3 and so on.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	f := &RotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends p, rotating first if p would take the file past MaxSize.
+// A single write is never split across files. If the rotation fails p is
+// still appended to the current file and the rotation error is returned.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Rotate rotates the file now, e.g. on SIGHUP.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups and starts a new file. If any step fails the
+// current path is reopened so that logging carries on, and the original
+// error is returned.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		err = f.shift()
+	}
+	if err != nil {
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) shift() error {
+	last := f.opts.MaxBackups
+	if last == 0 {
+		// keep everything, find the first free slot
+		for last = 1; ; last++ {
+			if _, err := os.Stat(f.backup(last)); errors.Is(err, os.ErrNotExist) {
+				break
+			}
+		}
+	} else if err := os.Remove(f.backup(last)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := last - 1; i >= 1; i-- {
+		if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.backup(1))
+}
+
+func (f *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
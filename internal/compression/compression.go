@@ -0,0 +1,156 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/negotiate"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+const defaultMinSize = 1024
+
+// supportedEncodings in the order the server prefers them when the client
+// gives them equal weight.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// incompressibleTypes are already compressed, or streamed, so compressing
+// them only costs CPU or delays delivery.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/octet-stream",
+	"text/event-stream",
+}
+
+type Options struct {
+	// Level is the gzip/zlib compression level, 0 or a level out of range
+	// uses the default.
+	Level int
+	// MinSize skips bodies with a smaller Content-Length, defaults to 1 KiB.
+	// Bodies of unknown length are always compressed.
+	MinSize int
+}
+
+// Compress returns a middleware that compresses responses with gzip or
+// deflate according to the request's Accept-Encoding. CONNECT tunnels and
+// responses that can't have a body are left untouched.
+func Compress(opts Options, next server.Handler) server.Handler {
+	level := opts.Level
+	if level == 0 || level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	minSize := opts.MinSize
+	if minSize == 0 {
+		minSize = defaultMinSize
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			zw, _ := gzip.NewWriterLevel(io.Discard, level)
+			return zw
+		}},
+		"deflate": {New: func() any {
+			zw, _ := zlib.NewWriterLevel(io.Discard, level)
+			return zw
+		}},
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "CONNECT" {
+			next(w, req)
+			return
+		}
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		encoding := SelectEncoding(acceptEncoding)
+		head := req.RequestLine.Method == "HEAD"
+
+		w.SetBodyEncoder(func(statusCode response.StatusCode, h headers.Headers) func(io.Writer) io.WriteCloser {
+			if statusCode < 200 || statusCode == 204 || statusCode == 304 {
+				return nil
+			}
+			addVary(h, "Accept-Encoding")
+			if encoding == "" || head || !compressible(h, minSize) {
+				return nil
+			}
+			h.Override("Content-Encoding", encoding)
+			pool := pools[encoding]
+			return func(dst io.Writer) io.WriteCloser {
+				zw := pool.Get().(resetWriteCloser)
+				zw.Reset(dst)
+				return &pooledWriter{resetWriteCloser: zw, pool: pool}
+			}
+		})
+		next(w, req)
+	}
+}
+
+// SelectEncoding picks the supported content coding with the highest
+// q-value in acceptEncoding, or "" when the body should be sent as is.
+func SelectEncoding(acceptEncoding string) string {
+	encoding, _ := negotiate.Best(negotiate.Encoding, acceptEncoding, supportedEncodings)
+	return encoding
+}
+
+func compressible(h headers.Headers, minSize int) bool {
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	if length, ok := h.Get("Content-Length"); ok {
+		n, err := strconv.Atoi(length)
+		if err == nil && n < minSize {
+			return false
+		}
+	}
+	contentType, _ := h.Get("Content-Type")
+	contentType = strings.ToLower(contentType)
+	if strings.HasPrefix(contentType, "image/svg") {
+		return true
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func addVary(h headers.Headers, name string) {
+	if headers.HasToken(h, "Vary", "*") || headers.HasToken(h, "Vary", name) {
+		return
+	}
+	h.Set("Vary", name)
+}
+
+type resetWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledWriter returns the compressor to its pool once the body is done.
+type pooledWriter struct {
+	resetWriteCloser
+	pool *sync.Pool
+}
+
+func (p *pooledWriter) Close() error {
+	err := p.resetWriteCloser.Close()
+	p.resetWriteCloser.Reset(io.Discard)
+	p.pool.Put(p.resetWriteCloser)
+	return err
+}
@@ -0,0 +1,216 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+var largeBody = strings.Repeat("a fairly compressible sentence. ", 100)
+
+func handler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/small":
+		body := "tiny"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	case "/empty":
+		w.WriteStatusLine(204)
+		w.WriteHeaders(headers.NewHeaders())
+	case "/image":
+		h := response.GetDefaultHeaders(len(largeBody))
+		h.Override("Content-Type", "image/png")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(largeBody))
+	case "/trailers":
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte(largeBody[:100]))
+		w.WriteChunkedBody([]byte(largeBody[100:]))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	default:
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(largeBody)))
+		w.WriteBody([]byte(largeBody))
+	}
+}
+
+func get(t *testing.T, conn net.Conn, br *bufio.Reader, target, acceptEncoding string) (*http.Response, []byte) {
+	t.Helper()
+	_, err := io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: "+acceptEncoding+"\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestCompress(t *testing.T) {
+	s, err := server.Serve(0, Compress(Options{}, handler))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	// Test: gzip body on a kept-alive connection
+	resp, body := get(t, conn, br, "/", "gzip, deflate")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	zr, err := gzip.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, largeBody, string(plain))
+
+	// Test: deflate preferred by q-value
+	resp, body = get(t, conn, br, "/", "gzip;q=0.5, deflate")
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	fr, err := zlib.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(fr)
+	require.NoError(t, err)
+	assert.Equal(t, largeBody, string(plain))
+
+	// Test: Small bodies are sent as is
+	resp, body = get(t, conn, br, "/small", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, "tiny", string(body))
+
+	// Test: Already compressed types are sent as is
+	resp, body = get(t, conn, br, "/image", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, largeBody, string(body))
+
+	// Test: Chunked body keeps its trailers
+	resp, body = get(t, conn, br, "/trailers", "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+	zr, err = gzip.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, largeBody, string(plain))
+
+	// Test: Nothing acceptable
+	resp, body = get(t, conn, br, "/", "br, gzip;q=0")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, largeBody, string(body))
+
+	// Test: Bodyless statuses get no headers
+	resp, _ = get(t, conn, br, "/empty", "gzip")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+}
+
+func TestCompressConnect(t *testing.T) {
+	// Test: The 200 opening a tunnel is left as is
+	var w bytes.Buffer
+	writer := response.NewWriter(&w)
+	req, err := request.RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+	Compress(Options{}, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers.NewHeaders())
+	})(writer, req)
+	assert.NotContains(t, strings.ToLower(w.String()), "content-encoding")
+	assert.NotContains(t, strings.ToLower(w.String()), "transfer-encoding")
+	assert.NotContains(t, strings.ToLower(w.String()), "vary")
+}
+
+func TestCompressStreaming(t *testing.T) {
+	// Test: Every chunk is flushed through the compressor
+	release := make(chan struct{})
+	s, err := server.Serve(0, Compress(Options{}, func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "application/x-ndjson")
+		h.Set("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("{\"n\":1}\n"))
+		<-release
+		w.WriteChunkedBody([]byte("{\"n\":2}\n"))
+		w.WriteChunkedBodyDone()
+	}))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	defer close(release)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	first := make([]byte, len("{\"n\":1}\n"))
+	_, err = io.ReadFull(zr, first)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"n\":1}\n", string(first))
+}
+
+func TestCompressInvalidLevel(t *testing.T) {
+	// Test: Out of range levels fall back to the default
+	s, err := server.Serve(0, Compress(Options{Level: 10}, handler))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	resp, body := get(t, conn, bufio.NewReader(conn), "/", "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	zr, err := gzip.NewReader(strings.NewReader(string(body)))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, largeBody, string(plain))
+}
+
+func TestSelectEncoding(t *testing.T) {
+	assert.Equal(t, "", SelectEncoding(""))
+	assert.Equal(t, "gzip", SelectEncoding("gzip"))
+	assert.Equal(t, "gzip", SelectEncoding("deflate, gzip"))
+	assert.Equal(t, "deflate", SelectEncoding("gzip;q=0.2, deflate;q=0.8"))
+	assert.Equal(t, "gzip", SelectEncoding("x-gzip"))
+	assert.Equal(t, "gzip", SelectEncoding("*"))
+	assert.Equal(t, "deflate", SelectEncoding("*;q=0.5, gzip;q=0"))
+	assert.Equal(t, "", SelectEncoding("identity, br"))
+	assert.Equal(t, "", SelectEncoding("gzip;q=2"))
+}
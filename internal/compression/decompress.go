@@ -0,0 +1,117 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+const defaultMaxDecompressedSize = 10 << 20
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errBodyTooLarge        = errors.New("decompressed body too large")
+)
+
+type DecompressOptions struct {
+	// MaxDecompressedSize caps the decoded body to defend against
+	// decompression bombs, defaults to 10 MiB.
+	MaxDecompressedSize int64
+}
+
+// Decompress returns a middleware that decodes gzip and deflate request
+// bodies before next sees them. Requests with other encodings get a 415,
+// bodies over the size limit a 413.
+func Decompress(opts DecompressOptions, next server.Handler) server.Handler {
+	limit := opts.MaxDecompressedSize
+	if limit <= 0 {
+		limit = defaultMaxDecompressedSize
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		contentEncoding, ok := req.Headers.Get("Content-Encoding")
+		if !ok {
+			next(w, req)
+			return
+		}
+
+		body, err := decodeBody(req.Body, contentEncoding, limit)
+		switch {
+		case errors.Is(err, errUnsupportedEncoding):
+			writeError(w, response.UnsupportedMedia, err)
+			return
+		case errors.Is(err, errBodyTooLarge):
+			writeError(w, response.PayloadTooLarge, err)
+			return
+		case err != nil:
+			writeError(w, response.BadRequest, err)
+			return
+		}
+
+		req.Body = body
+		req.Headers.Delete("Content-Encoding")
+		req.Headers.Override("Content-Length", strconv.Itoa(len(body)))
+		next(w, req)
+	}
+}
+
+// decodeBody undoes the codings in contentEncoding, which are listed in
+// the order they were applied.
+func decodeBody(body []byte, contentEncoding string, limit int64) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		var r io.ReadCloser
+		var err error
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			r, err = zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				// some clients send raw deflate without the zlib wrapper
+				r, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, coding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s body: %w", coding, err)
+		}
+
+		decoded, err := io.ReadAll(io.LimitReader(r, limit+1))
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s body: %w", coding, err)
+		}
+		if int64(len(decoded)) > limit {
+			return nil, errBodyTooLarge
+		}
+		body = decoded
+	}
+	return body, nil
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, err error) {
+	body := fmt.Sprintf("%s\n", err)
+	h := response.GetDefaultHeaders(len(body))
+	if statusCode == response.UnsupportedMedia {
+		h.Set("Accept-Encoding", strings.Join(supportedEncodings, ", "))
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
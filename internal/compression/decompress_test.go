@@ -0,0 +1,96 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+func echoBody(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+	w.WriteBody(req.Body)
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func post(t *testing.T, addr, contentEncoding string, body []byte) (*http.Response, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Encoding: "+contentEncoding+"\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func TestDecompress(t *testing.T) {
+	s, err := server.Serve(0, Decompress(DecompressOptions{MaxDecompressedSize: 1024}, echoBody))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	addr := s.Addr().String()
+
+	// Test: gzip body is decoded
+	resp, body := post(t, addr, "gzip", gzipped(t, `{"hello":"world"}`))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"hello":"world"}`, body)
+
+	// Test: zlib and raw deflate bodies are decoded
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("zlib wrapped"))
+	zw.Close()
+	_, body = post(t, addr, "deflate", buf.Bytes())
+	assert.Equal(t, "zlib wrapped", body)
+
+	buf.Reset()
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write([]byte("raw deflate"))
+	fw.Close()
+	_, body = post(t, addr, "deflate", buf.Bytes())
+	assert.Equal(t, "raw deflate", body)
+
+	// Test: Unsupported encoding
+	resp, _ = post(t, addr, "br", []byte("whatever"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	assert.Equal(t, "gzip, deflate", resp.Header.Get("Accept-Encoding"))
+
+	// Test: Decompression bomb
+	resp, _ = post(t, addr, "gzip", gzipped(t, strings.Repeat("0", 4096)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Test: Corrupt body
+	resp, _ = post(t, addr, "gzip", []byte("not gzip at all"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
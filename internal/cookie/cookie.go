@@ -0,0 +1,201 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is in seconds. Zero leaves the attribute out, a negative value
+	// sends Max-Age=0 so the client deletes the cookie right away.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// FromRequest parses the Cookie header of req into name/value pairs,
+// skipping malformed ones.
+func FromRequest(req *request.Request) []*Cookie {
+	header, ok := req.Headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	return Parse(header)
+}
+
+// Get returns the first cookie called name sent with req.
+func Get(req *request.Request, name string) (*Cookie, bool) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Parse parses a Cookie header value like "a=1; b=2". Several Cookie
+// headers joined by headers.Set are separated by a comma, which is
+// accepted as well outside of quoted values.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range splitPairs(header) {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !isToken(name) {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// splitPairs splits header on semicolons and on commas that aren't in a
+// quoted value.
+func splitPairs(header string) []string {
+	var pairs []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			quoted = !quoted
+		case ';', ',':
+			if header[i] == ',' && quoted {
+				continue
+			}
+			pairs = append(pairs, header[start:i])
+			start = i + 1
+			quoted = false
+		}
+	}
+	return append(pairs, header[start:])
+}
+
+// Set adds a Set-Cookie line for c to the response, it has to be called
+// before the headers are written.
+func Set(w *response.Writer, c *Cookie) error {
+	value, err := c.headerValue()
+	if err != nil {
+		return err
+	}
+	return w.AddHeader("Set-Cookie", value)
+}
+
+func (c *Cookie) String() string {
+	value, err := c.headerValue()
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+func (c *Cookie) headerValue() (string, error) {
+	if !isToken(c.Name) {
+		return "", fmt.Errorf("cookie: invalid name %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return "", fmt.Errorf("cookie: invalid value for %q", c.Name)
+	}
+	if strings.ContainsAny(c.Path, ";\r\n") || strings.ContainsAny(c.Domain, ";\r\n ") {
+		return "", fmt.Errorf("cookie: invalid attributes for %q", c.Name)
+	}
+	if c.Partitioned && !c.Secure {
+		return "", errors.New("cookie: Partitioned requires Secure")
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return "", errors.New("cookie: SameSite=None requires Secure")
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name + "=")
+	if strings.ContainsAny(c.Value, " ,") {
+		b.WriteString(`"` + c.Value + `"`)
+	} else {
+		b.WriteString(c.Value)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(http.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue allows the cookie-octets of RFC 6265 plus space and comma,
+// which are sent quoted.
+func validValue(s string) bool {
+	for _, r := range s {
+		if r < ' ' || r >= 0x7f || r == '"' || r == ';' || r == '\\' {
+			return false
+		}
+	}
+	return true
+}
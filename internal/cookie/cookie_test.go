@@ -0,0 +1,106 @@
+package cookie
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs with quoted values and junk
+	cookies := Parse(`session=abc123; theme="dark mode"; bad name=x; empty=; novalue`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "dark mode", cookies[1].Value)
+	assert.Equal(t, "empty", cookies[2].Name)
+	assert.Equal(t, "", cookies[2].Value)
+
+	// Test: Cookie headers joined by headers.Set
+	h := headers.NewHeaders()
+	h.Set("Cookie", "a=1")
+	h.Set("Cookie", "b=2")
+	req := &request.Request{Headers: h}
+	c, ok := Get(req, "b")
+	require.True(t, ok)
+	assert.Equal(t, "2", c.Value)
+	_, ok = Get(req, "c")
+	assert.False(t, ok)
+
+	// Test: Commas in quoted values
+	cookies = Parse(`a="x,y", b=2`)
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "x,y", cookies[0].Value)
+	assert.Equal(t, "2", cookies[1].Value)
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	// Test: Values written by String parse back unchanged
+	for _, value := range []string{"plain", "x,y", "with space", "a, b,c", ""} {
+		c := &Cookie{Name: "v", Value: value}
+		cookies := Parse(c.String() + "; other=1")
+		require.Len(t, cookies, 2, value)
+		assert.Equal(t, value, cookies[0].Value)
+		assert.Equal(t, "1", cookies[1].Value)
+	}
+}
+
+func TestString(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     expires,
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.Equal(t, "id=a3fWa; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 02:04:05 GMT; "+
+		"Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	assert.Equal(t, "gone=; Max-Age=0", (&Cookie{Name: "gone", MaxAge: -1}).String())
+	assert.Equal(t, `q="a b"; SameSite=Lax`, (&Cookie{Name: "q", Value: "a b", SameSite: SameSiteLax}).String())
+
+	// Test: Invalid cookies
+	assert.Empty(t, (&Cookie{Name: "bad name"}).String())
+	assert.Empty(t, (&Cookie{Name: "v", Value: "semi;colon"}).String())
+	assert.Empty(t, (&Cookie{Name: "p", Partitioned: true}).String())
+	assert.Empty(t, (&Cookie{Name: "s", SameSite: SameSiteNone}).String())
+}
+
+func TestSet(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		Set(w, &Cookie{Name: "a", Value: "1", HttpOnly: true})
+		Set(w, &Cookie{Name: "b", Value: "2", Path: "/"})
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	// Test: Each cookie gets its own Set-Cookie line
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; HttpOnly", "b=2; Path=/"}, resp.Header.Values("Set-Cookie"))
+}
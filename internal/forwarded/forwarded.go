@@ -0,0 +1,293 @@
+package forwarded
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+// Element is one hop of a Forwarded header. Each proxy appends the element
+// describing the request it received.
+type Element struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+type Options struct {
+	// TrustedProxies are the networks of the proxies whose forwarding
+	// headers are believed. Headers from any other peer are ignored.
+	TrustedProxies []netip.Prefix
+}
+
+type schemeKey struct{}
+
+// Resolve returns a handler that, for requests from trusted proxies, finds
+// the client by walking Forwarded, or X-Forwarded-For when there is no
+// Forwarded header, from the nearest hop back until an untrusted address.
+// It then rewrites RemoteAddr to that client, with port 0 when the proxies
+// didn't pass one, and Host to the host the client asked for, and records
+// the scheme for Scheme.
+func Resolve(opts Options, next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		peer, ok := parseAddr(req.RemoteAddr)
+		if !ok || !trusted(opts.TrustedProxies, peer) {
+			next(w, req)
+			return
+		}
+
+		hop, ok := resolve(req, opts.TrustedProxies)
+		if !ok {
+			next(w, req)
+			return
+		}
+		if hop.For != "" {
+			host, port := splitNode(hop.For)
+			if addr, ok := parseAddr(host); ok {
+				if port == "" {
+					port = "0"
+				}
+				req.RemoteAddr = net.JoinHostPort(addr.String(), port)
+			}
+		}
+		if hop.Host != "" {
+			req.Headers.Override("Host", hop.Host)
+		}
+		if proto := strings.ToLower(hop.Proto); proto == "http" || proto == "https" {
+			req = req.WithContext(context.WithValue(req.Context(), schemeKey{}, proto))
+		}
+		next(w, req)
+	}
+}
+
+// Scheme returns the scheme the client used, as resolved by Resolve, or
+// else the scheme of the connection.
+func Scheme(req *request.Request) string {
+	if scheme, ok := req.Context().Value(schemeKey{}).(string); ok {
+		return scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// resolve returns the hop written by the trusted proxy nearest to the
+// client.
+func resolve(req *request.Request, trustedProxies []netip.Prefix) (Element, bool) {
+	var hops []Element
+	if value, ok := req.Headers.Get("Forwarded"); ok {
+		elements, err := Parse(value)
+		if err != nil {
+			return Element{}, false
+		}
+		hops = elements
+	} else {
+		hops = xForwardedHops(req)
+	}
+	if len(hops) == 0 {
+		return Element{}, false
+	}
+
+	i := len(hops) - 1
+	for i > 0 {
+		host, _ := splitNode(hops[i].For)
+		addr, ok := parseAddr(host)
+		if !ok || !trusted(trustedProxies, addr) {
+			break
+		}
+		i--
+	}
+	return hops[i], true
+}
+
+// xForwardedHops lines up X-Forwarded-For with X-Forwarded-Proto and -Host.
+// Lists of another length than X-Forwarded-For contribute their last
+// value, which the nearest proxy set.
+func xForwardedHops(req *request.Request) []Element {
+	forValue, ok := req.Headers.Get("X-Forwarded-For")
+	if !ok {
+		return nil
+	}
+	fors := splitList(forValue)
+	protoValue, _ := req.Headers.Get("X-Forwarded-Proto")
+	protos := splitList(protoValue)
+	hostValue, _ := req.Headers.Get("X-Forwarded-Host")
+	hosts := splitList(hostValue)
+
+	hops := make([]Element, len(fors))
+	for i, node := range fors {
+		hops[i].For = node
+		hops[i].Proto = aligned(protos, i, len(fors))
+		hops[i].Host = aligned(hosts, i, len(fors))
+	}
+	return hops
+}
+
+func aligned(values []string, i, n int) string {
+	switch {
+	case len(values) == n:
+		return values[i]
+	case len(values) > 0:
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Parse parses an RFC 7239 Forwarded header value.
+func Parse(value string) ([]Element, error) {
+	var elements []Element
+	var current Element
+	empty := true
+	for i := 0; i < len(value); {
+		switch value[i] {
+		case ' ', '\t', ';':
+			i++
+			continue
+		case ',':
+			if !empty {
+				elements = append(elements, current)
+			}
+			current, empty = Element{}, true
+			i++
+			continue
+		}
+
+		eq := strings.IndexByte(value[i:], '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("forwarded: missing '=' at offset %d", i)
+		}
+		name := strings.ToLower(value[i : i+eq])
+		if !isToken(name) {
+			return nil, fmt.Errorf("forwarded: invalid parameter %q", name)
+		}
+		i += eq + 1
+
+		var paramValue string
+		if i < len(value) && value[i] == '"' {
+			unquoted, n, err := readQuoted(value[i:])
+			if err != nil {
+				return nil, err
+			}
+			paramValue, i = unquoted, i+n
+		} else {
+			end := i
+			for end < len(value) && value[end] != ';' && value[end] != ',' {
+				end++
+			}
+			paramValue = strings.TrimSpace(value[i:end])
+			if !validValue(paramValue) {
+				return nil, fmt.Errorf("forwarded: invalid value %q", paramValue)
+			}
+			i = end
+		}
+
+		switch name {
+		case "for":
+			current.For = paramValue
+		case "by":
+			current.By = paramValue
+		case "host":
+			current.Host = paramValue
+		case "proto":
+			current.Proto = paramValue
+		}
+		empty = false
+	}
+	if !empty {
+		elements = append(elements, current)
+	}
+	return elements, nil
+}
+
+// readQuoted reads the quoted-string at the start of s and returns its
+// content and the number of bytes consumed.
+func readQuoted(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("forwarded: unterminated quoted string")
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return "", 0, fmt.Errorf("forwarded: unterminated quoted string")
+}
+
+// splitNode splits a node like "192.0.2.1", "[2001:db8::1]:4711" or
+// "unknown" into its host and port.
+func splitNode(node string) (host, port string) {
+	if host, port, err := net.SplitHostPort(node); err == nil {
+		return host, port
+	}
+	return strings.Trim(node, "[]"), ""
+}
+
+func parseAddr(hostport string) (netip.Addr, bool) {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func trusted(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue accepts unquoted values that should have been tokens but
+// which proxies commonly send bare, like IPv6 nodes and "host:port".
+func validValue(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package forwarded
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+var trustedProxies = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("2001:db8:ffff::/48"),
+}
+
+// resolveRequest runs Resolve for a request from peer and returns the
+// request the next handler saw.
+func resolveRequest(t *testing.T, peer, headers string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: internal\r\n" + headers + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = peer
+
+	var seen *request.Request
+	Resolve(Options{TrustedProxies: trustedProxies}, func(w *response.Writer, req *request.Request) {
+		seen = req
+	})(response.NewWriter(&bytes.Buffer{}), req)
+	require.NotNil(t, seen)
+	return seen
+}
+
+func host(req *request.Request) string {
+	h, _ := req.Headers.Get("Host")
+	return h
+}
+
+func TestParse(t *testing.T) {
+	// Test: Quoted values, case-insensitive names and empty elements
+	elements, err := Parse(`for=192.0.2.60;proto=http;by=203.0.113.43, ,For="[2001:db8:cafe::17]:4711";Host="example.com";PROTO=https`)
+	require.NoError(t, err)
+	assert.Equal(t, []Element{
+		{For: "192.0.2.60", By: "203.0.113.43", Proto: "http"},
+		{For: "[2001:db8:cafe::17]:4711", Host: "example.com", Proto: "https"},
+	}, elements)
+
+	// Test: Escapes in quoted strings
+	elements, err = Parse(`for="_hidden\"x"`)
+	require.NoError(t, err)
+	assert.Equal(t, `_hidden"x`, elements[0].For)
+
+	invalid := []string{`for`, `for="unterminated`, `f o r=1`, `for=a b`}
+	for _, value := range invalid {
+		_, err := Parse(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestResolveForwarded(t *testing.T) {
+	// Test: Walks back past trusted hops
+	req := resolveRequest(t, "10.0.0.2:4000",
+		"Forwarded: for=198.51.100.7, for=\"[2001:db8:cafe::17]:4711\";proto=https;host=example.com, for=10.0.0.1;proto=http;host=lb\r\n")
+	assert.Equal(t, "[2001:db8:cafe::17]:4711", req.RemoteAddr)
+	assert.Equal(t, "example.com", host(req))
+	assert.Equal(t, "https", Scheme(req))
+
+	// Test: Untrusted peers are ignored
+	req = resolveRequest(t, "192.0.2.9:4000", "Forwarded: for=198.51.100.7;proto=https\r\n")
+	assert.Equal(t, "192.0.2.9:4000", req.RemoteAddr)
+	assert.Equal(t, "internal", host(req))
+	assert.Equal(t, "http", Scheme(req))
+
+	// Test: Obfuscated client keeps the peer address
+	req = resolveRequest(t, "10.0.0.2:4000", "Forwarded: for=_hidden;proto=https\r\n")
+	assert.Equal(t, "10.0.0.2:4000", req.RemoteAddr)
+	assert.Equal(t, "https", Scheme(req))
+
+	// Test: Malformed header is ignored
+	req = resolveRequest(t, "10.0.0.2:4000", "Forwarded: for=\"broken\r\n")
+	assert.Equal(t, "10.0.0.2:4000", req.RemoteAddr)
+}
+
+func TestResolveXForwarded(t *testing.T) {
+	// Test: Client before the trusted proxies, port unknown
+	req := resolveRequest(t, "10.0.0.2:4000",
+		"X-Forwarded-For: 203.0.113.1, 198.51.100.7, 10.0.0.1\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: example.com\r\n")
+	assert.Equal(t, "198.51.100.7:0", req.RemoteAddr)
+	assert.Equal(t, "example.com", host(req))
+	assert.Equal(t, "https", Scheme(req))
+
+	// Test: Lists of equal length line up
+	req = resolveRequest(t, "[2001:db8:ffff::1]:4000",
+		"X-Forwarded-For: 2001:db8::5, 10.1.2.3\r\nX-Forwarded-Proto: https, http\r\n")
+	assert.Equal(t, "[2001:db8::5]:0", req.RemoteAddr)
+	assert.Equal(t, "https", Scheme(req))
+
+	// Test: All hops trusted picks the first
+	req = resolveRequest(t, "10.0.0.2:4000", "X-Forwarded-For: 10.0.0.3, 10.0.0.4\r\n")
+	assert.Equal(t, "10.0.0.3:0", req.RemoteAddr)
+
+	// Test: Forwarded wins over X-Forwarded-For
+	req = resolveRequest(t, "10.0.0.2:4000", "Forwarded: for=198.51.100.1\r\nX-Forwarded-For: 198.51.100.2\r\n")
+	assert.Equal(t, "198.51.100.1:0", req.RemoteAddr)
+
+	// Test: No forwarding headers
+	req = resolveRequest(t, "10.0.0.2:4000", "")
+	assert.Equal(t, "10.0.0.2:4000", req.RemoteAddr)
+}
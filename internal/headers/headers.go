@@ -70,9 +70,25 @@ func (h Headers) Override(key, value string) {
 }
 
 func (h Headers) Delete(key string) {
+	key = strings.ToLower(key)
 	delete(h, key)
 }
 
+// HasToken reports whether the comma-separated list in the header name
+// contains token, compared case-insensitively.
+func HasToken(h Headers, name, token string) bool {
+	value, ok := h.Get(name)
+	if !ok {
+		return false
+	}
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
 func keyIsValid(s string) bool {
 	for _, char := range s {
 		if !isAllowedKeyChar(char) {
@@ -75,3 +75,25 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
+
+func TestHeadersDelete(t *testing.T) {
+	// Test: Delete is case insensitive
+	headers := NewHeaders()
+	headers.Set("Content-Length", "10")
+	headers.Delete("Content-Length")
+	_, ok := headers.Get("content-length")
+	assert.False(t, ok)
+}
+
+func TestHasToken(t *testing.T) {
+	headers := NewHeaders()
+	headers.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, HasToken(headers, "connection", "upgrade"))
+	assert.True(t, HasToken(headers, "Connection", "keep-alive"))
+	assert.False(t, HasToken(headers, "Connection", "close"))
+	assert.False(t, HasToken(headers, "Upgrade", "websocket"))
+
+	// Test: Tokens are whole list items
+	headers.Set("Vary", "Accept-Encoding-X")
+	assert.False(t, HasToken(headers, "Vary", "Accept-Encoding"))
+}
@@ -0,0 +1,129 @@
+package jsonio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+const defaultMaxBytes = 1 << 20
+
+type BindOptions struct {
+	// MaxBytes caps the body size, 1MiB by default.
+	MaxBytes int
+	// DisallowUnknownFields rejects objects with fields v has no place for.
+	DisallowUnknownFields bool
+}
+
+// Validator is implemented by bound values that check themselves after
+// decoding. A failing Validate makes Bind return a 422 Error.
+type Validator interface {
+	Validate() error
+}
+
+// Error describes why a body couldn't be bound, along with the status the
+// client should get for it.
+type Error struct {
+	Status  response.StatusCode `json:"-"`
+	Message string              `json:"error"`
+	// Field is the JSON path of the offending value, when known.
+	Field string `json:"field,omitempty"`
+	// Offset is the byte offset of a syntax error.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return e.Message
+}
+
+// Bind decodes the JSON body of req into v. Errors are always *Error:
+// 415 for a non-JSON Content-Type, 413 for a body over the limit, 400 for
+// malformed JSON and 422 for JSON that doesn't fit v or fails validation.
+func Bind(req *request.Request, v any, opts BindOptions) error {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+
+	contentType, _ := req.Headers.Get("Content-Type")
+	if !isJSON(contentType) {
+		return &Error{Status: response.UnsupportedMedia, Message: "content type must be application/json"}
+	}
+	if len(req.Body) > opts.MaxBytes {
+		return &Error{Status: response.PayloadTooLarge, Message: fmt.Sprintf("body exceeds %d bytes", opts.MaxBytes)}
+	}
+	if len(bytes.TrimSpace(req.Body)) == 0 {
+		return &Error{Status: response.BadRequest, Message: "body is empty"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return &Error{Status: response.BadRequest, Message: "body must contain a single JSON value", Offset: dec.InputOffset()}
+	}
+
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			var bindErr *Error
+			if errors.As(err, &bindErr) {
+				return bindErr
+			}
+			return &Error{Status: response.UnprocessableEntity, Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// WriteError writes err as a JSON error body, using its status if it is an
+// *Error and 500 otherwise.
+func WriteError(w *response.Writer, err error) error {
+	var bindErr *Error
+	if !errors.As(err, &bindErr) {
+		bindErr = &Error{Status: response.InternalServerError, Message: err.Error()}
+	}
+	return Write(w, bindErr.Status, bindErr)
+}
+
+func decodeError(err error) *Error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &Error{Status: response.BadRequest, Message: "malformed JSON: " + syntaxErr.Error(), Offset: syntaxErr.Offset}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &Error{Status: response.BadRequest, Message: "malformed JSON: unexpected end of body"}
+	case errors.As(err, &typeErr):
+		return &Error{
+			Status:  response.UnprocessableEntity,
+			Message: fmt.Sprintf("expected %s, got JSON %s", typeErr.Type, typeErr.Value),
+			Field:   typeErr.Field,
+			Offset:  typeErr.Offset,
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &Error{Status: response.UnprocessableEntity, Message: "unknown field", Field: field}
+	}
+	return &Error{Status: response.BadRequest, Message: err.Error()}
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,137 @@
+package jsonio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+type item struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (i *item) Validate() error {
+	if i.Name == "" {
+		return &Error{Status: response.UnprocessableEntity, Message: "is required", Field: "name"}
+	}
+	return nil
+}
+
+func newRequest(t *testing.T, contentType, body string) *request.Request {
+	t.Helper()
+	raw := "POST /items HTTP/1.1\r\nHost: localhost\r\n"
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	req, err := request.RequestFromReader(bytes.NewBufferString(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func bindError(t *testing.T, err error) *Error {
+	t.Helper()
+	var bindErr *Error
+	require.True(t, errors.As(err, &bindErr), "expected *Error, got %v", err)
+	return bindErr
+}
+
+func TestBind(t *testing.T) {
+	// Test: Valid body
+	var v item
+	require.NoError(t, Bind(newRequest(t, "application/json; charset=utf-8", `{"name":"a","count":2}`), &v, BindOptions{}))
+	assert.Equal(t, item{Name: "a", Count: 2}, v)
+
+	// Test: Structured suffix is JSON
+	require.NoError(t, Bind(newRequest(t, "application/vnd.api+json", `{"name":"a"}`), &item{}, BindOptions{}))
+
+	// Test: Wrong content type
+	err := Bind(newRequest(t, "text/plain", `{"name":"a"}`), &item{}, BindOptions{})
+	assert.Equal(t, response.UnsupportedMedia, bindError(t, err).Status)
+
+	// Test: Body over the limit
+	err = Bind(newRequest(t, "application/json", `{"name":"abcdef"}`), &item{}, BindOptions{MaxBytes: 8})
+	assert.Equal(t, response.PayloadTooLarge, bindError(t, err).Status)
+
+	// Test: Malformed JSON
+	err = Bind(newRequest(t, "application/json", `{"name":}`), &item{}, BindOptions{})
+	assert.Equal(t, response.BadRequest, bindError(t, err).Status)
+	assert.NotZero(t, bindError(t, err).Offset)
+
+	// Test: Truncated JSON
+	err = Bind(newRequest(t, "application/json", `{"name":"a"`), &item{}, BindOptions{})
+	assert.Equal(t, response.BadRequest, bindError(t, err).Status)
+
+	// Test: Trailing data
+	err = Bind(newRequest(t, "application/json", `{"name":"a"} {}`), &item{}, BindOptions{})
+	assert.Equal(t, response.BadRequest, bindError(t, err).Status)
+
+	// Test: Wrong type names the field
+	err = Bind(newRequest(t, "application/json", `{"name":"a","count":"two"}`), &item{}, BindOptions{})
+	assert.Equal(t, response.UnprocessableEntity, bindError(t, err).Status)
+	assert.Equal(t, "count", bindError(t, err).Field)
+
+	// Test: Unknown field
+	err = Bind(newRequest(t, "application/json", `{"name":"a","extra":1}`), &item{}, BindOptions{DisallowUnknownFields: true})
+	assert.Equal(t, response.UnprocessableEntity, bindError(t, err).Status)
+	assert.Equal(t, "extra", bindError(t, err).Field)
+
+	// Test: Validation failure
+	err = Bind(newRequest(t, "application/json", `{"count":1}`), &item{}, BindOptions{})
+	assert.Equal(t, response.UnprocessableEntity, bindError(t, err).Status)
+	assert.Equal(t, "name", bindError(t, err).Field)
+}
+
+func TestWrite(t *testing.T) {
+	// Test: Body and framing
+	var buf bytes.Buffer
+	require.NoError(t, Write(response.NewWriter(&buf), response.OK, item{Name: "a", Count: 1}))
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "{\"name\":\"a\",\"count\":1}\n", string(body))
+	assert.EqualValues(t, len(body), resp.ContentLength)
+
+	// Test: Errors carry their status
+	buf.Reset()
+	require.NoError(t, WriteError(response.NewWriter(&buf), &Error{Status: response.UnprocessableEntity, Message: "is required", Field: "name"}))
+	resp, err = http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"is required","field":"name"}`, string(body))
+}
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := NewStream(response.NewWriter(&buf), response.OK)
+	require.NoError(t, err)
+	require.NoError(t, s.Send(item{Name: "a"}))
+	require.NoError(t, s.Send(item{Name: "b"}))
+	require.NoError(t, s.Close())
+
+	// Test: Sending after Close
+	assert.ErrorIs(t, s.Send(item{}), ErrClosed)
+
+	// Test: One value per line
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"name\":\"a\",\"count\":0}\n{\"name\":\"b\",\"count\":0}\n", string(body))
+}
@@ -0,0 +1,94 @@
+package jsonio
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+const contentType = "application/json; charset=utf-8"
+
+var ErrClosed = errors.New("jsonio: stream closed")
+
+// Write encodes v and writes it as the whole response with statusCode.
+func Write(w *response.Writer, statusCode response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// Stream writes newline delimited JSON values as a chunked response, one
+// chunk per value so clients can process them as they arrive. Close must be
+// called once the last value is sent.
+type Stream struct {
+	w *response.Writer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewStream writes the status line and headers of an application/x-ndjson
+// response to w.
+func NewStream(w *response.Writer, statusCode response.StatusCode) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "application/x-ndjson")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	return &Stream{w: w}, nil
+}
+
+func (s *Stream) Send(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, err := s.w.WriteChunkedBody(line); err != nil {
+		s.closed = true
+		return err
+	}
+	return nil
+}
+
+// Close ends the chunked body.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("jobs_total", "Jobs done.\nBy queue.", "queue")
+	g := r.NewGauge("workers", "Busy workers.")
+	h := r.NewHistogram("job_seconds", "Job latency.", []float64{1, 0.1})
+
+	c.Inc(`fast"lane`)
+	c.Add(2, "default")
+	g.Set(3)
+	g.Dec()
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var b strings.Builder
+	_, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP jobs_total Jobs done.\nBy queue.
+# TYPE jobs_total counter
+jobs_total{queue="default"} 2
+jobs_total{queue="fast\"lane"} 1
+# HELP workers Busy workers.
+# TYPE workers gauge
+workers 2
+# HELP job_seconds Job latency.
+# TYPE job_seconds histogram
+job_seconds_bucket{le="0.1"} 1
+job_seconds_bucket{le="1"} 2
+job_seconds_bucket{le="+Inf"} 3
+job_seconds_sum 5.55
+job_seconds_count 3
+`, b.String())
+
+	// Test: Duplicate names panic
+	assert.Panics(t, func() { r.NewGauge("workers", "") })
+
+	// Test: Wrong label count panics
+	assert.Panics(t, func() { c.Inc() })
+}
+
+func TestServerMetrics(t *testing.T) {
+	r := NewRegistry()
+	m := NewServerMetrics(r)
+	hello := m.Instrument("/hello", func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(5))
+		w.WriteBody([]byte("hello"))
+	})
+	expose := m.Instrument("/metrics", r.Handler())
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/metrics" {
+			expose(w, req)
+			return
+		}
+		hello(w, req)
+	}, server.WithObserver(m))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	_, err = io.WriteString(conn, "GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+
+	// Test: Unknown methods share one label value
+	_, err = io.WriteString(conn, "BREW /hello HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+
+	// Test: Parse errors are counted by type
+	bad, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = io.WriteString(bad, "GET / HTTP/9.9\r\n\r\n")
+	require.NoError(t, err)
+	io.ReadAll(bad)
+	bad.Close()
+
+	scrape := func() string {
+		_, err := io.WriteString(conn, "GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	exposition := scrape()
+	assert.Contains(t, exposition, `http_requests_total{route="/hello",method="GET",status="200"} 1`)
+	assert.Contains(t, exposition, `http_requests_total{route="/hello",method="OTHER",status="200"} 1`)
+	assert.NotContains(t, exposition, `method="BREW"`)
+	assert.Contains(t, exposition, `http_request_duration_seconds_count{route="/hello",method="GET"} 1`)
+	assert.Contains(t, exposition, `http_response_body_bytes_total{route="/hello"} 10`)
+	assert.Contains(t, exposition, `http_parse_errors_total{type="request_line"} 1`)
+	assert.Contains(t, exposition, "http_connections_total 2\n")
+	assert.NotContains(t, exposition, "http_read_bytes_total 0\n")
+	assert.NotContains(t, exposition, "http_written_bytes_total 0\n")
+
+	// Test: Closed connections leave the active gauge
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrape(), "http_connections_active 1\n")
+	}, 2*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+// knownMethods are the methods recorded as is, others are recorded as OTHER
+// so clients can't create label values at will.
+var knownMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+}
+
+// ServerMetrics are the standard metrics of a server. Pass it to
+// server.WithObserver for the connection metrics and wrap handlers with
+// Instrument for the request metrics.
+type ServerMetrics struct {
+	requests    *Counter
+	duration    *Histogram
+	responseLen *Counter
+
+	connsActive *Gauge
+	connsTotal  *Counter
+	bytesRead   *Counter
+	bytesWrote  *Counter
+	parseErrors *Counter
+}
+
+func NewServerMetrics(r *Registry) *ServerMetrics {
+	return &ServerMetrics{
+		requests:    r.NewCounter("http_requests_total", "Requests served by route, method and status.", "route", "method", "status"),
+		duration:    r.NewHistogram("http_request_duration_seconds", "Time spent in the handler by route and method.", DefaultBuckets, "route", "method"),
+		responseLen: r.NewCounter("http_response_body_bytes_total", "Response body bytes by route.", "route"),
+		connsActive: r.NewGauge("http_connections_active", "Connections currently open."),
+		connsTotal:  r.NewCounter("http_connections_total", "Connections accepted."),
+		bytesRead:   r.NewCounter("http_read_bytes_total", "Bytes read from connections."),
+		bytesWrote:  r.NewCounter("http_written_bytes_total", "Bytes written to connections."),
+		parseErrors: r.NewCounter("http_parse_errors_total", "Requests that could not be parsed by error type.", "type"),
+	}
+}
+
+// Instrument records the requests next serves under route. Routes should be
+// a fixed set, like "/users/:id", not raw request targets.
+func (m *ServerMetrics) Instrument(route string, next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+
+		method := req.RequestLine.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+		m.duration.Observe(time.Since(start).Seconds(), route, method)
+		m.requests.Inc(route, method, strconv.Itoa(int(w.StatusCode())))
+		m.responseLen.Add(float64(w.BodyBytes()), route)
+	}
+}
+
+func (m *ServerMetrics) ConnOpened() {
+	m.connsActive.Inc()
+	m.connsTotal.Inc()
+}
+
+func (m *ServerMetrics) ConnClosed() {
+	m.connsActive.Dec()
+}
+
+func (m *ServerMetrics) BytesRead(n int) {
+	m.bytesRead.Add(float64(n))
+}
+
+func (m *ServerMetrics) BytesWritten(n int) {
+	m.bytesWrote.Add(float64(n))
+}
+
+func (m *ServerMetrics) ParseError(kind string) {
+	m.parseErrors.Inc(kind)
+}
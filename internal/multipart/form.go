@@ -0,0 +1,126 @@
+package multipart
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// Form holds a fully read multipart body. Field values are kept in memory,
+// file parts larger than MaxMemory live in temporary files until RemoveAll.
+type Form struct {
+	Values map[string][]string
+	Files  map[string][]*FilePart
+}
+
+type FilePart struct {
+	FormName    string
+	FileName    string
+	ContentType string
+	Size        int64
+
+	data []byte
+	path string
+}
+
+// Open returns the part content, from memory or from its temporary file.
+func (f *FilePart) Open() (io.ReadCloser, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// OnDisk reports whether the part was spilled to a temporary file.
+func (f *FilePart) OnDisk() bool {
+	return f.path != ""
+}
+
+// ReadForm reads every part. On error the temporary files created so far
+// are removed.
+func (r *Reader) ReadForm() (*Form, error) {
+	form := &Form{
+		Values: make(map[string][]string),
+		Files:  make(map[string][]*FilePart),
+	}
+	for {
+		part, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		if part.FileName == "" {
+			value, err := io.ReadAll(part)
+			if err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+			form.Values[part.FormName] = append(form.Values[part.FormName], string(value))
+			continue
+		}
+
+		file, err := r.storeFile(part)
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		form.Files[part.FormName] = append(form.Files[part.FormName], file)
+	}
+}
+
+func (r *Reader) storeFile(part *Part) (*FilePart, error) {
+	file := &FilePart{
+		FormName:    part.FormName,
+		FileName:    part.FileName,
+		ContentType: part.ContentType(),
+	}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, r.opts.MaxMemory+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n <= r.opts.MaxMemory {
+		file.data = buf.Bytes()
+		file.Size = n
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp(r.opts.TempDir, "multipart-")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(tmp, io.MultiReader(&buf, part))
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	file.path = tmp.Name()
+	file.Size = size
+	return file, nil
+}
+
+// RemoveAll deletes the temporary files of spilled parts.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.path == "" {
+				continue
+			}
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,259 @@
+package multipart
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+)
+
+const (
+	readerBufferSize     = 4096
+	maxPartHeaderSize    = 16 << 10
+	defaultMaxPartSize   = 32 << 20
+	defaultMaxTotalSize  = 64 << 20
+	defaultMaxParts      = 1000
+	defaultMaxMemorySize = 1 << 20
+)
+
+var (
+	ErrPartTooLarge  = errors.New("multipart: part too large")
+	ErrBodyTooLarge  = errors.New("multipart: body too large")
+	ErrTooManyParts  = errors.New("multipart: too many parts")
+	ErrNotMultipart  = errors.New("multipart: request is not multipart/form-data")
+	errMalformedBody = errors.New("multipart: malformed body")
+)
+
+type Options struct {
+	// MaxPartSize caps the body of a single part, defaults to 32 MiB.
+	MaxPartSize int64
+	// MaxTotalSize caps the sum of all part bodies, defaults to 64 MiB.
+	MaxTotalSize int64
+	// MaxParts defaults to 1000.
+	MaxParts int
+	// MaxMemory is how much of a file part ReadForm keeps in memory before
+	// spilling it to a temporary file, defaults to 1 MiB.
+	MaxMemory int64
+	// TempDir for spilled parts, os.TempDir when empty.
+	TempDir string
+}
+
+// Reader iterates over the parts of a multipart body. Each part's body is
+// streamed from the underlying reader, so it must be read before moving to
+// the next part.
+type Reader struct {
+	br             *bufio.Reader
+	dashBoundary   []byte
+	nlDashBoundary []byte
+	opts           Options
+
+	current *Part
+	parts   int
+	total   int64
+	started bool
+	done    bool
+}
+
+// NewRequestReader returns a Reader over the body of a multipart/form-data
+// request, taking the boundary from its Content-Type.
+func NewRequestReader(req *request.Request, opts Options) (*Reader, error) {
+	contentType, _ := req.Headers.Get("Content-Type")
+	boundary, err := Boundary(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(bytes.NewReader(req.Body), boundary, opts), nil
+}
+
+// Boundary returns the boundary parameter of a multipart/form-data
+// Content-Type.
+func Boundary(contentType string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return "", errors.New("multipart: invalid boundary")
+	}
+	return boundary, nil
+}
+
+func NewReader(r io.Reader, boundary string, opts Options) *Reader {
+	if opts.MaxPartSize <= 0 {
+		opts.MaxPartSize = defaultMaxPartSize
+	}
+	if opts.MaxTotalSize <= 0 {
+		opts.MaxTotalSize = defaultMaxTotalSize
+	}
+	if opts.MaxParts <= 0 {
+		opts.MaxParts = defaultMaxParts
+	}
+	if opts.MaxMemory <= 0 {
+		opts.MaxMemory = defaultMaxMemorySize
+	}
+	return &Reader{
+		br:             bufio.NewReaderSize(r, readerBufferSize),
+		dashBoundary:   []byte("--" + boundary),
+		nlDashBoundary: []byte("\r\n--" + boundary),
+		opts:           opts,
+	}
+}
+
+type Part struct {
+	Headers headers.Headers
+	// FormName and FileName come from the Content-Disposition header,
+	// FileName is empty for plain form fields.
+	FormName string
+	FileName string
+
+	r    *Reader
+	size int64
+	eof  bool
+}
+
+// NextPart skips whatever is left of the current part and returns the next
+// one, or io.EOF after the last.
+func (r *Reader) NextPart() (*Part, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	if r.current != nil {
+		if _, err := io.Copy(io.Discard, r.current); err != nil {
+			return nil, err
+		}
+		r.current = nil
+	}
+
+	if !r.started {
+		if err := r.skipPreamble(); err != nil {
+			return nil, err
+		}
+		r.started = true
+	} else {
+		if _, err := r.br.Discard(len(r.nlDashBoundary)); err != nil {
+			return nil, errMalformedBody
+		}
+		line, err := r.br.ReadSlice('\n')
+		if bytes.HasPrefix(line, []byte("--")) {
+			r.done = true
+			return nil, io.EOF
+		}
+		if err != nil || len(bytes.TrimSpace(line)) != 0 {
+			return nil, errMalformedBody
+		}
+	}
+
+	r.parts++
+	if r.parts > r.opts.MaxParts {
+		return nil, ErrTooManyParts
+	}
+	h, err := r.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+
+	part := &Part{Headers: h, r: r}
+	if disposition, ok := h.Get("Content-Disposition"); ok {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			part.FormName = params["name"]
+			part.FileName = params["filename"]
+		}
+	}
+	r.current = part
+	return part, nil
+}
+
+func (r *Reader) skipPreamble() error {
+	for {
+		line, err := r.br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// preamble lines are ignored whatever their length
+			continue
+		}
+		if err != nil {
+			return errMalformedBody
+		}
+		line = bytes.TrimRight(line, " \t\r\n")
+		if bytes.Equal(line, r.dashBoundary) {
+			return nil
+		}
+		if bytes.Equal(line, append(r.dashBoundary, "--"...)) {
+			return errMalformedBody
+		}
+	}
+}
+
+func (r *Reader) readPartHeaders() (headers.Headers, error) {
+	h := headers.NewHeaders()
+	size := 0
+	for {
+		line, err := r.br.ReadSlice('\n')
+		if err != nil {
+			return nil, errMalformedBody
+		}
+		size += len(line)
+		if size > maxPartHeaderSize {
+			return nil, errors.New("multipart: part headers too large")
+		}
+		_, done, err := h.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("multipart: %w", err)
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// Read reads the part body up to the next boundary.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	r := p.r
+	delimiter := r.nlDashBoundary
+
+	peek, err := r.br.Peek(r.br.Size())
+	n := 0
+	if idx := bytes.Index(peek, delimiter); idx >= 0 {
+		n = copy(b, peek[:idx])
+		if n == idx {
+			p.eof = true
+		}
+	} else {
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		// the tail could be the start of a delimiter split across reads
+		n = copy(b, peek[:len(peek)-len(delimiter)+1])
+	}
+	r.br.Discard(n)
+
+	p.size += int64(n)
+	r.total += int64(n)
+	if p.size > r.opts.MaxPartSize {
+		return n, ErrPartTooLarge
+	}
+	if r.total > r.opts.MaxTotalSize {
+		return n, ErrBodyTooLarge
+	}
+	if p.eof && n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (p *Part) ContentType() string {
+	contentType, ok := p.Headers.Get("Content-Type")
+	if !ok {
+		return "text/plain"
+	}
+	return strings.TrimSpace(contentType)
+}
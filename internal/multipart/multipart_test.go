@@ -0,0 +1,152 @@
+package multipart
+
+import (
+	"bytes"
+	"io"
+	stdmultipart "mime/multipart"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+)
+
+var largeFile = strings.Repeat("0123456789abcdef", 1024)
+
+// buildBody encodes fields and a file with the standard library writer.
+func buildBody(t *testing.T) (string, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := stdmultipart.NewWriter(&buf)
+	require.NoError(t, mw.WriteField("title", "holiday photos"))
+	require.NoError(t, mw.WriteField("tag", "beach"))
+	require.NoError(t, mw.WriteField("tag", "2024"))
+	fw, err := mw.CreateFormFile("upload", "notes.txt")
+	require.NoError(t, err)
+	_, err = io.WriteString(fw, largeFile)
+	require.NoError(t, err)
+	_, err = mw.CreateFormFile("upload", "empty.txt")
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+func newRequest(contentType string, body []byte) *request.Request {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", contentType)
+	return &request.Request{Headers: h, Body: body}
+}
+
+func TestReaderParts(t *testing.T) {
+	contentType, body := buildBody(t)
+	r, err := NewRequestReader(newRequest(contentType, body), Options{})
+	require.NoError(t, err)
+
+	// Test: Fields come back in order with their headers
+	part, err := r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName)
+	assert.Empty(t, part.FileName)
+	value, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "holiday photos", string(value))
+
+	// Test: Unread parts are skipped
+	_, err = r.NextPart()
+	require.NoError(t, err)
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "tag", part.FormName)
+
+	// Test: File part body spanning several buffers is streamed intact
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "upload", part.FormName)
+	assert.Equal(t, "notes.txt", part.FileName)
+	assert.Equal(t, "application/octet-stream", part.ContentType())
+	value, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, largeFile, string(value))
+
+	// Test: Empty part and end of body
+	part, err = r.NextPart()
+	require.NoError(t, err)
+	value, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Empty(t, value)
+	_, err = r.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadForm(t *testing.T) {
+	contentType, body := buildBody(t)
+
+	// Test: Large files spill to disk
+	r, err := NewRequestReader(newRequest(contentType, body), Options{MaxMemory: 1024, TempDir: t.TempDir()})
+	require.NoError(t, err)
+	form, err := r.ReadForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"holiday photos"}, form.Values["title"])
+	assert.Equal(t, []string{"beach", "2024"}, form.Values["tag"])
+	require.Len(t, form.Files["upload"], 2)
+
+	file := form.Files["upload"][0]
+	assert.True(t, file.OnDisk())
+	assert.Equal(t, int64(len(largeFile)), file.Size)
+	rc, err := file.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, largeFile, string(data))
+	assert.False(t, form.Files["upload"][1].OnDisk())
+
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(file.path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Part size limit
+	r, err = NewRequestReader(newRequest(contentType, body), Options{MaxPartSize: 1024})
+	require.NoError(t, err)
+	_, err = r.ReadForm()
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+
+	// Test: Total size limit
+	r, err = NewRequestReader(newRequest(contentType, body), Options{MaxTotalSize: 2048})
+	require.NoError(t, err)
+	_, err = r.ReadForm()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Part count limit
+	r, err = NewRequestReader(newRequest(contentType, body), Options{MaxParts: 2})
+	require.NoError(t, err)
+	_, err = r.ReadForm()
+	assert.ErrorIs(t, err, ErrTooManyParts)
+}
+
+func TestMalformed(t *testing.T) {
+	// Test: Not multipart
+	_, err := NewRequestReader(newRequest("application/json", nil), Options{})
+	assert.ErrorIs(t, err, ErrNotMultipart)
+
+	// Test: Missing boundary
+	_, err = Boundary("multipart/form-data")
+	assert.Error(t, err)
+
+	// Test: Truncated body
+	r := NewReader(strings.NewReader("--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nunterminated"), "xyz", Options{})
+	part, err := r.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Preamble is skipped
+	r = NewReader(strings.NewReader("ignore me\r\n--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nvalue\r\n--xyz--\r\n"), "xyz", Options{})
+	form, err := r.ReadForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"value"}, form.Values["a"])
+}
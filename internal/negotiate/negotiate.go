@@ -0,0 +1,237 @@
+package negotiate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+// Spec is one entry of an Accept-style header.
+type Spec struct {
+	// Value is the media range, language range, charset or coding, lower cased.
+	Value string
+	Q     float64
+	// Params holds media type parameters other than q.
+	Params map[string]string
+}
+
+type Kind int
+
+const (
+	MediaType Kind = iota
+	Language
+	Charset
+	Encoding
+)
+
+var headerNames = map[Kind]string{
+	MediaType: "Accept",
+	Language:  "Accept-Language",
+	Charset:   "Accept-Charset",
+	Encoding:  "Accept-Encoding",
+}
+
+// Parse splits an Accept-style header into specs ranked by q-value, with
+// more specific media ranges first among equal weights. Entries with an
+// invalid q-value are dropped.
+func Parse(header string) []Spec {
+	var specs []Spec
+	for _, entry := range strings.Split(header, ",") {
+		params := strings.Split(entry, ";")
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if value == "" {
+			continue
+		}
+		spec := Spec{Value: value, Q: 1}
+		valid := true
+		for _, param := range params[1:] {
+			name, paramValue, _ := strings.Cut(strings.TrimSpace(param), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			paramValue = strings.Trim(strings.TrimSpace(paramValue), `"`)
+			if name == "q" {
+				q, err := strconv.ParseFloat(paramValue, 64)
+				if err != nil || q < 0 || q > 1 {
+					valid = false
+				}
+				spec.Q = q
+				// anything after q is an accept-ext, not a media type parameter
+				break
+			}
+			if spec.Params == nil {
+				spec.Params = make(map[string]string)
+			}
+			spec.Params[name] = paramValue
+		}
+		if valid {
+			specs = append(specs, spec)
+		}
+	}
+
+	sort.SliceStable(specs, func(i, j int) bool {
+		if specs[i].Q != specs[j].Q {
+			return specs[i].Q > specs[j].Q
+		}
+		return mediaSpecificity(specs[i]) > mediaSpecificity(specs[j])
+	})
+	return specs
+}
+
+// ParseRequest parses the header of req that kind is negotiated on.
+func ParseRequest(req *request.Request, kind Kind) []Spec {
+	header, _ := req.Headers.Get(headerNames[kind])
+	return Parse(header)
+}
+
+// Best picks the offer the header ranks highest, preferring earlier offers
+// on ties. It reports false when no offer is acceptable.
+func Best(kind Kind, header string, offers []string) (string, bool) {
+	specs := Parse(header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q := quality(kind, specs, offer)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// Negotiate picks the best media type from offers for req's Accept header.
+// Without an Accept header the first offer wins.
+func Negotiate(req *request.Request, offers []string) (string, bool) {
+	return negotiate(req, MediaType, offers)
+}
+
+func NegotiateLanguage(req *request.Request, offers []string) (string, bool) {
+	return negotiate(req, Language, offers)
+}
+
+func NegotiateCharset(req *request.Request, offers []string) (string, bool) {
+	return negotiate(req, Charset, offers)
+}
+
+// NegotiateEncoding picks a content coding. Without an Accept-Encoding
+// header no coding is chosen, as sending the body as is is always safe.
+func NegotiateEncoding(req *request.Request, offers []string) (string, bool) {
+	header, ok := req.Headers.Get("Accept-Encoding")
+	if !ok {
+		return "", false
+	}
+	return Best(Encoding, header, offers)
+}
+
+func negotiate(req *request.Request, kind Kind, offers []string) (string, bool) {
+	header, ok := req.Headers.Get(headerNames[kind])
+	if !ok || strings.TrimSpace(header) == "" {
+		if len(offers) == 0 {
+			return "", false
+		}
+		return offers[0], true
+	}
+	return Best(kind, header, offers)
+}
+
+// NotAcceptable writes a 406 response listing the offers.
+func NotAcceptable(w *response.Writer, offers []string) error {
+	body := fmt.Sprintf("not acceptable, available: %s\n", strings.Join(offers, ", "))
+	if err := w.WriteStatusLine(response.NotAcceptable); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+		return err
+	}
+	_, err := w.WriteBody([]byte(body))
+	return err
+}
+
+// quality returns the q-value of the most specific spec matching offer.
+func quality(kind Kind, specs []Spec, offer string) float64 {
+	offer = strings.ToLower(offer)
+	bestSpecificity, q := -1, 0.0
+	for _, spec := range specs {
+		specificity := match(kind, spec, offer)
+		if specificity > bestSpecificity {
+			bestSpecificity, q = specificity, spec.Q
+		}
+	}
+	return q
+}
+
+// match returns how specifically spec matches offer, or -1 if it doesn't.
+func match(kind Kind, spec Spec, offer string) int {
+	switch kind {
+	case MediaType:
+		return matchMediaType(spec, offer)
+	case Language:
+		if spec.Value == "*" {
+			return 0
+		}
+		if offer == spec.Value || strings.HasPrefix(offer, spec.Value+"-") {
+			return len(spec.Value)
+		}
+	case Charset:
+		if spec.Value == "*" {
+			return 0
+		}
+		if offer == spec.Value {
+			return 1
+		}
+	case Encoding:
+		if spec.Value == "*" {
+			return 0
+		}
+		if offer == spec.Value || (offer == "gzip" && spec.Value == "x-gzip") {
+			return 1
+		}
+	}
+	return -1
+}
+
+func matchMediaType(spec Spec, offer string) int {
+	offerType, offerParams, _ := strings.Cut(offer, ";")
+	offerType = strings.TrimSpace(offerType)
+	mainType, _, _ := strings.Cut(offerType, "/")
+
+	specificity := mediaSpecificity(spec)
+	switch {
+	case spec.Value == "*/*":
+	case strings.HasSuffix(spec.Value, "/*"):
+		if strings.TrimSuffix(spec.Value, "/*") != mainType {
+			return -1
+		}
+	case spec.Value != offerType:
+		return -1
+	}
+
+	if len(spec.Params) > 0 {
+		params := make(map[string]string)
+		for _, param := range strings.Split(offerParams, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok {
+				params[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+		for name, value := range spec.Params {
+			if params[name] != value {
+				return -1
+			}
+		}
+	}
+	return specificity
+}
+
+func mediaSpecificity(spec Spec) int {
+	switch {
+	case spec.Value == "*/*":
+		return 0
+	case strings.HasSuffix(spec.Value, "/*"):
+		return 1
+	case len(spec.Params) > 0:
+		return 3
+	}
+	return 2
+}
@@ -0,0 +1,101 @@
+package negotiate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+func newRequest(t *testing.T, headers string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(bytes.NewBufferString("GET / HTTP/1.1\r\nHost: localhost\r\n" + headers + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestParse(t *testing.T) {
+	// Test: Ranked by q, then specificity
+	specs := Parse("text/*;q=0.5, */*;q=0.1, text/html;level=1, text/html, application/json;q=0.5")
+	require.Len(t, specs, 5)
+	assert.Equal(t, "text/html", specs[0].Value)
+	assert.Equal(t, map[string]string{"level": "1"}, specs[0].Params)
+	assert.Equal(t, "text/html", specs[1].Value)
+	assert.Nil(t, specs[1].Params)
+	assert.Equal(t, "application/json", specs[2].Value)
+	assert.Equal(t, "text/*", specs[3].Value)
+	assert.Equal(t, 0.1, specs[4].Q)
+
+	// Test: Invalid q-values are dropped
+	specs = Parse("gzip;q=2, deflate;q=abc, br")
+	require.Len(t, specs, 1)
+	assert.Equal(t, "br", specs[0].Value)
+
+	// Test: Empty header
+	assert.Empty(t, Parse(""))
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "text/html"}
+
+	// Test: Highest q wins
+	offer, ok := Negotiate(newRequest(t, "Accept: application/json;q=0.5, text/html\r\n"), offers)
+	assert.True(t, ok)
+	assert.Equal(t, "text/html", offer)
+
+	// Test: Most specific range decides
+	offer, ok = Negotiate(newRequest(t, "Accept: text/*, text/html;q=0\r\n"), []string{"text/html", "text/plain"})
+	assert.True(t, ok)
+	assert.Equal(t, "text/plain", offer)
+
+	// Test: Server order breaks ties
+	offer, ok = Negotiate(newRequest(t, "Accept: */*\r\n"), offers)
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", offer)
+
+	// Test: No Accept header takes the first offer
+	offer, ok = Negotiate(newRequest(t, ""), offers)
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", offer)
+
+	// Test: Parameters must match
+	offer, ok = Negotiate(newRequest(t, "Accept: text/html;level=1\r\n"), []string{"text/html;level=2", "text/html;level=1"})
+	assert.True(t, ok)
+	assert.Equal(t, "text/html;level=1", offer)
+
+	// Test: Nothing acceptable
+	_, ok = Negotiate(newRequest(t, "Accept: image/png\r\n"), offers)
+	assert.False(t, ok)
+}
+
+func TestNegotiateLanguageCharsetEncoding(t *testing.T) {
+	// Test: Language prefix matching
+	offer, ok := NegotiateLanguage(newRequest(t, "Accept-Language: de;q=0.5, en\r\n"), []string{"de-DE", "en-US"})
+	assert.True(t, ok)
+	assert.Equal(t, "en-US", offer)
+
+	// Test: Charset is case-insensitive
+	offer, ok = NegotiateCharset(newRequest(t, "Accept-Charset: UTF-8, *;q=0.1\r\n"), []string{"iso-8859-1", "utf-8"})
+	assert.True(t, ok)
+	assert.Equal(t, "utf-8", offer)
+
+	// Test: Encoding wildcard with exclusion
+	offer, ok = NegotiateEncoding(newRequest(t, "Accept-Encoding: *, gzip;q=0\r\n"), []string{"gzip", "deflate"})
+	assert.True(t, ok)
+	assert.Equal(t, "deflate", offer)
+
+	// Test: No Accept-Encoding header picks no coding
+	_, ok = NegotiateEncoding(newRequest(t, ""), []string{"gzip"})
+	assert.False(t, ok)
+}
+
+func TestNotAcceptable(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NotAcceptable(response.NewWriter(&buf), []string{"application/json", "text/html"}))
+	assert.Contains(t, buf.String(), "HTTP/1.1 406 Not Acceptable")
+	assert.Contains(t, buf.String(), "available: application/json, text/html")
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+type Rule struct {
+	Allow bool
+	// Pattern is "host" for any port or "host:port". A host starting with
+	// "*." matches its subdomains, a lone "*" matches every host.
+	Pattern string
+}
+
+// ACL decides which targets the proxy may reach. Rules are checked in
+// order and the first match wins; targets no rule matches are refused.
+type ACL []Rule
+
+// ParseACL reads one rule per line in the form "allow <pattern>" or
+// "deny <pattern>". Blank lines and lines starting with '#' are skipped.
+func ParseACL(r io.Reader) (ACL, error) {
+	var acl ACL
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("acl line %d: expected \"allow|deny <pattern>\"", lineNumber)
+		}
+		switch fields[0] {
+		case "allow":
+			acl = append(acl, Rule{Allow: true, Pattern: fields[1]})
+		case "deny":
+			acl = append(acl, Rule{Allow: false, Pattern: fields[1]})
+		default:
+			return nil, fmt.Errorf("acl line %d: unknown action %q", lineNumber, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (acl ACL) Allows(host, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, rule := range acl {
+		if matches(rule.Pattern, host, port) {
+			return rule.Allow
+		}
+	}
+	return false
+}
+
+func matches(pattern, host, port string) bool {
+	patternHost, patternPort := pattern, ""
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		patternHost, patternPort = h, p
+	}
+	if patternPort != "" && patternPort != port {
+		return false
+	}
+	patternHost = strings.ToLower(patternHost)
+	if patternHost == "*" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(patternHost, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return patternHost == host
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+const (
+	defaultDialTimeout = 10 * time.Second
+	defaultIdleTimeout = 5 * time.Minute
+	spliceBufferSize   = 32 * 1024
+)
+
+// Dialer opens connections to tunnel targets, *net.Dialer satisfies it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+type ConnectOptions struct {
+	// Dialer defaults to a *net.Dialer with a 10 second timeout.
+	Dialer Dialer
+	// ACL holds the targets tunnels may be opened to, an empty ACL refuses
+	// every target.
+	ACL ACL
+	// IdleTimeout closes the tunnel when no bytes flow in either direction
+	// for this long, defaults to 5 minutes.
+	IdleTimeout time.Duration
+}
+
+// Connect returns a handler that serves CONNECT requests by opening a TCP
+// tunnel to the authority in the request target. Other methods are passed
+// to next.
+func Connect(opts ConnectOptions, next server.Handler) server.Handler {
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: defaultDialTimeout}
+	}
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "CONNECT" {
+			next(w, req)
+			return
+		}
+
+		host, port, err := request.SplitAuthority(req.RequestLine.RequestTarget)
+		if err != nil {
+			writeError(w, response.BadRequest, err.Error())
+			return
+		}
+		if !opts.ACL.Allows(host, port) {
+			writeError(w, response.Forbidden, "target not allowed")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), defaultDialTimeout)
+		target, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+		cancel()
+		if err != nil {
+			writeError(w, response.BadGateway, "could not reach target")
+			return
+		}
+		defer target.Close()
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers.NewHeaders())
+		client, buffered, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		// tear the tunnel down when the server shuts down, the request's
+		// context would also end it at the request timeout
+		stop := context.AfterFunc(server.ConnContext(req), func() {
+			client.Close()
+			target.Close()
+		})
+		defer stop()
+
+		if len(buffered) > 0 {
+			if _, err := target.Write(buffered); err != nil {
+				return
+			}
+		}
+		splice(client, target, idleTimeout)
+	}
+}
+
+// splice copies bytes both ways until both sides are done or the tunnel
+// has been idle for idleTimeout.
+func splice(a, b net.Conn, idleTimeout time.Duration) {
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyIdle(b, a, idleTimeout, &lastActivity)
+	}()
+	go func() {
+		defer wg.Done()
+		copyIdle(a, b, idleTimeout, &lastActivity)
+	}()
+	wg.Wait()
+}
+
+// copyIdle copies src to dst, refreshing the read deadline while either
+// direction saw traffic within idleTimeout. When src is done it half-closes
+// dst so the other direction can still drain.
+func copyIdle(dst, src net.Conn, idleTimeout time.Duration, lastActivity *atomic.Int64) {
+	defer func() {
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}()
+
+	buf := make([]byte, spliceBufferSize)
+	for {
+		src.SetReadDeadline(time.Now().Add(idleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			idle := time.Since(time.Unix(0, lastActivity.Load()))
+			if idle < idleTimeout {
+				continue
+			}
+			// wake up the other direction too
+			src.SetReadDeadline(time.Now())
+			dst.SetReadDeadline(time.Now())
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, message string) {
+	body := fmt.Sprintf("%s\n", message)
+	h := response.GetDefaultHeaders(len(body))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
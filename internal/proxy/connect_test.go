@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+func notFound(w *response.Writer, req *request.Request) {
+	writeError(w, response.BadRequest, "not a proxy request")
+}
+
+// startEchoTarget starts a TCP server echoing everything back and returns
+// its address.
+func startEchoTarget(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func startProxy(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+func sendConnect(t *testing.T, proxyAddr, target string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	require.NoError(t, err)
+	return conn, br, resp
+}
+
+type failingDialer struct{}
+
+func (failingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestConnect(t *testing.T) {
+	target := startEchoTarget(t)
+	proxyAddr := startProxy(t, Connect(ConnectOptions{ACL: ACL{{Allow: true, Pattern: target}}}, notFound))
+
+	// Test: Tunnel to an allowed target
+	conn, br, resp := sendConnect(t, proxyAddr, target)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err := io.WriteString(conn, "ping through tunnel")
+	require.NoError(t, err)
+	echoed := make([]byte, len("ping through tunnel"))
+	_, err = io.ReadFull(br, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "ping through tunnel", string(echoed))
+
+	// Test: Target not in the allowlist
+	_, _, resp = sendConnect(t, proxyAddr, "example.com:443")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Test: Non-CONNECT requests go to next
+	conn, err = net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestConnectDialFailure(t *testing.T) {
+	proxyAddr := startProxy(t, Connect(ConnectOptions{
+		Dialer: failingDialer{},
+		ACL:    ACL{{Allow: true, Pattern: "example.com:443"}},
+	}, notFound))
+
+	// Test: Dial errors become 502
+	_, _, resp := sendConnect(t, proxyAddr, "example.com:443")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestConnectOutlivesRequestTimeout(t *testing.T) {
+	target := startEchoTarget(t)
+	s, err := server.Serve(0, Connect(ConnectOptions{ACL: ACL{{Allow: true, Pattern: target}}}, notFound),
+		server.WithRequestTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The tunnel stays open past the request timeout
+	conn, br, resp := sendConnect(t, s.Addr().String(), target)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	time.Sleep(100 * time.Millisecond)
+	_, err = io.WriteString(conn, "still here")
+	require.NoError(t, err)
+	echoed := make([]byte, len("still here"))
+	_, err = io.ReadFull(br, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "still here", string(echoed))
+
+	// Test: Shutting the server down closes it
+	go s.Close()
+	_, err = io.ReadAll(br)
+	assert.NoError(t, err)
+}
+
+func TestConnectIdleTimeout(t *testing.T) {
+	target := startEchoTarget(t)
+	proxyAddr := startProxy(t, Connect(ConnectOptions{
+		ACL:         ACL{{Allow: true, Pattern: target}},
+		IdleTimeout: 50 * time.Millisecond,
+	}, notFound))
+
+	// Test: Idle tunnel is closed
+	conn, br, resp := sendConnect(t, proxyAddr, target)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	start := time.Now()
+	_, err := br.ReadByte()
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+	conn.Close()
+}
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+	"github.com/dmytrochumakov/httpfromtcp/internal/tracing"
+)
+
+// hopByHopHeaders only apply to a single connection and are never forwarded.
+var hopByHopHeaders = []string{
+	"connection",
+	"proxy-connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+type ForwardOptions struct {
+	// Dialer defaults to a *net.Dialer with a 10 second timeout.
+	Dialer Dialer
+	// ACL holds the origins requests may be forwarded to, an empty ACL
+	// refuses every origin.
+	ACL ACL
+	// Authenticate checks the Basic credentials from Proxy-Authorization.
+	// When nil no authentication is required.
+	Authenticate func(username, password string) bool
+	// Realm is sent in the Proxy-Authenticate challenge.
+	Realm string
+	// Tracer, when set, records a client span for each origin request and
+	// passes its context on in the traceparent header.
+	Tracer *tracing.Tracer
+}
+
+// Forward returns a handler that forwards absolute-form requests like
+// "GET http://host/path HTTP/1.1" to their origin and relays the response.
+// Requests with any other target form are passed to next.
+func Forward(opts ForwardOptions, next server.Handler) server.Handler {
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: defaultDialTimeout}
+	}
+	realm := opts.Realm
+	if realm == "" {
+		realm = "proxy"
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if !strings.Contains(req.RequestLine.RequestTarget, "://") {
+			next(w, req)
+			return
+		}
+
+		if opts.Authenticate != nil && !authorized(req, opts.Authenticate) {
+			body := "proxy authentication required\n"
+			h := response.GetDefaultHeaders(len(body))
+			h.Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			w.WriteStatusLine(response.ProxyAuthRequired)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(body))
+			return
+		}
+
+		target, err := url.Parse(req.RequestLine.RequestTarget)
+		if err != nil || target.Scheme != "http" || target.Host == "" {
+			writeError(w, response.BadRequest, "invalid absolute-form target")
+			return
+		}
+		host, port := target.Hostname(), target.Port()
+		if port == "" {
+			port = "80"
+		}
+		if !opts.ACL.Allows(host, port) {
+			writeError(w, response.Forbidden, "origin not allowed")
+			return
+		}
+
+		var span *tracing.Span
+		if opts.Tracer != nil {
+			span = startOriginSpan(opts.Tracer, req, target)
+			defer span.End()
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), defaultDialTimeout)
+		origin, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+		cancel()
+		if err != nil {
+			failSpan(span, err)
+			writeError(w, response.BadGateway, "could not reach origin")
+			return
+		}
+		defer origin.Close()
+		// unblock the exchange when the client goes away or the request
+		// times out
+		stop := context.AfterFunc(req.Context(), func() { origin.Close() })
+		defer stop()
+
+		if err := writeOriginRequest(origin, req, target, span); err != nil {
+			failSpan(span, err)
+			writeError(w, response.BadGateway, "could not send request to origin")
+			return
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(origin), &http.Request{Method: req.RequestLine.Method})
+		if err != nil {
+			failSpan(span, err)
+			writeError(w, response.BadGateway, "invalid response from origin")
+			return
+		}
+		defer resp.Body.Close()
+		if span != nil {
+			span.SetAttribute("http.response.status_code", resp.StatusCode)
+			if resp.StatusCode >= 400 {
+				span.SetStatus(tracing.StatusError, "")
+			}
+		}
+
+		relayResponse(w, resp)
+	}
+}
+
+func authorized(req *request.Request, authenticate func(username, password string) bool) bool {
+	value, ok := req.Headers.Get("Proxy-Authorization")
+	if !ok {
+		return false
+	}
+	scheme, credentials, ok := strings.Cut(value, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	return ok && authenticate(username, password)
+}
+
+// startOriginSpan starts the client span of a forwarded request, as a child
+// of the server span if the proxy is traced and else of the client's span.
+func startOriginSpan(tracer *tracing.Tracer, req *request.Request, target *url.URL) *tracing.Span {
+	parent, _ := tracing.Extract(req)
+	if serverSpan := tracing.SpanFromRequest(req); serverSpan != nil {
+		parent = serverSpan.Context()
+	}
+	span := tracer.Start(req.RequestLine.Method, tracing.SpanKindClient, parent)
+	span.SetAttribute("http.request.method", req.RequestLine.Method)
+	span.SetAttribute("url.full", target.String())
+	span.SetAttribute("server.address", target.Hostname())
+	return span
+}
+
+func failSpan(span *tracing.Span, err error) {
+	if span != nil {
+		span.SetStatus(tracing.StatusError, err.Error())
+	}
+}
+
+// writeOriginRequest sends req to the origin with the target rewritten to
+// origin-form and the hop-by-hop headers removed. With a span its context
+// replaces the client's traceparent.
+func writeOriginRequest(origin io.Writer, req *request.Request, target *url.URL, span *tracing.Span) error {
+	h := forwardableHeaders(req.Headers)
+	if span != nil {
+		tracing.Inject(h, span.Context())
+	}
+	h.Override("Host", target.Host)
+	h.Override("Connection", "close")
+	if len(req.Body) > 0 {
+		h.Override("Content-Length", strconv.Itoa(len(req.Body)))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", req.RequestLine.Method, target.RequestURI())
+	for key, value := range h {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+
+	if _, err := io.WriteString(origin, b.String()); err != nil {
+		return err
+	}
+	_, err := origin.Write(req.Body)
+	return err
+}
+
+func forwardableHeaders(src headers.Headers) headers.Headers {
+	h := headers.NewHeaders()
+	for key, value := range src {
+		h.Override(key, value)
+	}
+	// headers named in Connection are hop-by-hop as well
+	if connection, ok := src.Get("Connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			h.Delete(strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+	return h
+}
+
+func relayResponse(w *response.Writer, resp *http.Response) {
+	h := headers.NewHeaders()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			if key == "Set-Cookie" {
+				w.AddHeader(key, value)
+				continue
+			}
+			h.Set(key, value)
+		}
+	}
+	if connection := resp.Header.Get("Connection"); connection != "" {
+		for _, name := range strings.Split(connection, ",") {
+			h.Delete(strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Delete(name)
+	}
+
+	// HEAD responses and these statuses never have a body, whatever their
+	// headers say, so they get no framing either
+	if (resp.Request != nil && resp.Request.Method == "HEAD") ||
+		resp.StatusCode < 200 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+		w.WriteHeaders(h)
+		return
+	}
+
+	chunked := resp.ContentLength < 0
+	if chunked {
+		h.Delete("content-length")
+		h.Override("Transfer-Encoding", "chunked")
+	} else {
+		h.Override("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+
+	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	w.WriteHeaders(h)
+
+	buf := make([]byte, spliceBufferSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if chunked {
+				_, err = w.WriteChunkedBody(buf[:n])
+			} else {
+				_, err = w.WriteBody(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if chunked {
+		w.WriteChunkedBodyDone()
+	}
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+	"github.com/dmytrochumakov/httpfromtcp/internal/tracing"
+)
+
+// originHandler describes the request it received in the response body.
+func originHandler(w *response.Writer, req *request.Request) {
+	keys := make([]string, 0, len(req.Headers))
+	for key := range req.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s\n", req.RequestLine.Method, req.RequestLine.RequestTarget)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, req.Headers[key])
+	}
+	fmt.Fprintf(&b, "body=%s\n", req.Body)
+
+	body := b.String()
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("X-Origin", "yes")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+func proxyGet(t *testing.T, proxyAddr, raw string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, raw)
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestForward(t *testing.T) {
+	originAddr := startProxy(t, originHandler)
+	_, originPort, err := net.SplitHostPort(originAddr)
+	require.NoError(t, err)
+	origin := "localhost:" + originPort
+
+	proxyAddr := startProxy(t, Forward(ForwardOptions{
+		ACL: ACL{{Allow: true, Pattern: "localhost"}},
+		Authenticate: func(username, password string) bool {
+			return username == "alice" && password == "secret"
+		},
+	}, notFound))
+	credentials := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+
+	// Test: Absolute-form request is rewritten and forwarded
+	resp := proxyGet(t, proxyAddr, "POST http://"+origin+"/items?id=1 HTTP/1.1\r\n"+
+		"Host: "+origin+"\r\n"+
+		"Proxy-Authorization: Basic "+credentials+"\r\n"+
+		"Proxy-Connection: keep-alive\r\n"+
+		"Connection: X-Hop\r\n"+
+		"X-Hop: drop me\r\n"+
+		"X-Keep: keep me\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "yes", resp.Header.Get("X-Origin"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /items?id=1\n"+
+		"connection=close\n"+
+		"content-length=5\n"+
+		"host="+origin+"\n"+
+		"x-keep=keep me\n"+
+		"body=hello\n", string(body))
+
+	// Test: Missing credentials
+	resp = proxyGet(t, proxyAddr, "GET http://"+origin+"/ HTTP/1.1\r\nHost: "+origin+"\r\n\r\n")
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	assert.Equal(t, `Basic realm="proxy"`, resp.Header.Get("Proxy-Authenticate"))
+
+	// Test: Origin refused by the ACL
+	resp = proxyGet(t, proxyAddr, "GET http://127.0.0.1:"+originPort+"/ HTTP/1.1\r\n"+
+		"Proxy-Authorization: Basic "+credentials+"\r\n\r\n")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Test: Origin-form requests go to next
+	resp = proxyGet(t, proxyAddr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestForwardStalledOrigin(t *testing.T) {
+	// an origin that accepts connections and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, originPort, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	origin := "localhost:" + originPort
+
+	s, err := server.Serve(0, Forward(ForwardOptions{ACL: ACL{{Allow: true, Pattern: "localhost"}}}, notFound),
+		server.WithRequestTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The request timeout ends the wait for the origin
+	start := time.Now()
+	resp := proxyGet(t, s.Addr().String(), "GET http://"+origin+"/ HTTP/1.1\r\nHost: "+origin+"\r\n\r\n")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestForwardBodyless(t *testing.T) {
+	originAddr := startProxy(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		if req.RequestLine.RequestTarget == "/empty" {
+			w.WriteStatusLine(204)
+		} else {
+			w.WriteStatusLine(response.OK)
+		}
+		w.WriteHeaders(h)
+	})
+	_, originPort, err := net.SplitHostPort(originAddr)
+	require.NoError(t, err)
+	origin := "localhost:" + originPort
+	proxyAddr := startProxy(t, Forward(ForwardOptions{ACL: ACL{{Allow: true, Pattern: "localhost"}}}, notFound))
+
+	// Test: No chunked framing without a body
+	for _, line := range []string{"HEAD http://" + origin + "/ HTTP/1.1", "GET http://" + origin + "/empty HTTP/1.1"} {
+		conn, err := net.Dial("tcp", proxyAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.WriteString(conn, line+"\r\nHost: "+origin+"\r\nConnection: close\r\n\r\n")
+		require.NoError(t, err)
+		raw, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\n"), line)
+		assert.NotContains(t, strings.ToLower(string(raw)), "transfer-encoding", line)
+		assert.NotContains(t, string(raw), "\r\n\r\n0\r\n", line)
+	}
+}
+
+// spanCollector keeps exported spans in memory.
+type spanCollector struct {
+	spans []tracing.SpanData
+}
+
+func (c *spanCollector) Export(spans []tracing.SpanData) error {
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+func TestForwardTracing(t *testing.T) {
+	originAddr := startProxy(t, originHandler)
+	_, originPort, err := net.SplitHostPort(originAddr)
+	require.NoError(t, err)
+	origin := "localhost:" + originPort
+
+	collector := &spanCollector{}
+	tracer := tracing.NewTracer(collector, tracing.Options{})
+	proxyAddr := startProxy(t, tracing.Trace(tracer, Forward(ForwardOptions{
+		ACL:    ACL{{Allow: true, Pattern: "localhost"}},
+		Tracer: tracer,
+	}, notFound)))
+
+	// Test: Origin gets the client span as parent
+	resp := proxyGet(t, proxyAddr, "GET http://"+origin+"/ HTTP/1.1\r\n"+
+		"Host: "+origin+"\r\n"+
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n"+
+		"tracestate: rojo=1\r\n"+
+		"\r\n")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	tracer.Shutdown()
+
+	require.Len(t, collector.spans, 2)
+	client, server := collector.spans[0], collector.spans[1]
+	assert.Equal(t, tracing.SpanKindClient, client.Kind)
+	assert.Equal(t, tracing.SpanKindServer, server.Kind)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID.String())
+	assert.Equal(t, server.Context.SpanID, client.ParentSpanID)
+	assert.Equal(t, 200, client.Attributes["http.response.status_code"])
+	assert.Contains(t, string(body), "traceparent="+client.Context.Traceparent()+"\n")
+	assert.Contains(t, string(body), "tracestate=rojo=1\n")
+}
+
+func TestParseACL(t *testing.T) {
+	// Test: Rules in order, first match wins
+	acl, err := ParseACL(strings.NewReader(`
+# internal services
+deny  admin.internal.test
+allow *.internal.test
+allow example.com:443
+`))
+	require.NoError(t, err)
+	require.Len(t, acl, 3)
+	assert.False(t, acl.Allows("admin.internal.test", "80"))
+	assert.True(t, acl.Allows("db.internal.test", "5432"))
+	assert.False(t, acl.Allows("internal.test", "80"))
+	assert.True(t, acl.Allows("Example.com", "443"))
+	assert.False(t, acl.Allows("example.com", "80"))
+	assert.False(t, ACL(nil).Allows("localhost", "80"))
+	assert.True(t, ACL{{Allow: true, Pattern: "*"}}.Allows("anything", "1"))
+
+	// Test: Invalid lines
+	_, err = ParseACL(strings.NewReader("permit example.com\n"))
+	assert.Error(t, err)
+	_, err = ParseACL(strings.NewReader("allow\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,282 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107
+)
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrMalformed = errors.New("proxyproto: malformed header")
+
+type Command int
+
+const (
+	// CommandLocal is sent for connections the proxy opened itself, e.g.
+	// health checks. They carry no client address.
+	CommandLocal Command = iota
+	CommandProxy
+)
+
+type TLVType byte
+
+const (
+	TLVALPN      TLVType = 0x01
+	TLVAuthority TLVType = 0x02
+	TLVCRC32C    TLVType = 0x03
+	TLVNoop      TLVType = 0x04
+	TLVUniqueID  TLVType = 0x05
+	TLVSSL       TLVType = 0x20
+	TLVNetNS     TLVType = 0x30
+)
+
+type TLV struct {
+	Type  TLVType
+	Value []byte
+}
+
+// Header is a parsed PROXY protocol header.
+type Header struct {
+	Version int
+	Command Command
+	// Source and Destination are the client and the address it connected
+	// to, nil when the proxy didn't pass them (LOCAL, UNKNOWN or an
+	// unsupported address family).
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are the version 2 extensions.
+	TLVs []TLV
+}
+
+// TLV returns the value of the first extension of type t.
+func (h *Header) TLV(t TLVType) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Read parses a version 1 or 2 header from r. It reads no byte past the
+// header, so r can be a connection read by something else afterwards.
+func Read(r io.Reader) (*Header, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		return readV1(r)
+	case v2Signature[0]:
+		return readV2(r)
+	}
+	return nil, fmt.Errorf("%w: no PROXY header", ErrMalformed)
+}
+
+// readV1 reads the rest of a text header like
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n" one byte at a time, as its
+// length isn't known up front.
+func readV1(r io.Reader) (*Header, error) {
+	line := []byte{v1Prefix[0]}
+	var b [1]byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == v1MaxLength {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrMalformed)
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if b[0] == '\n' && line[len(line)-1] != '\r' {
+			return nil, fmt.Errorf("%w: v1 header not terminated by CRLF", ErrMalformed)
+		}
+		line = append(line, b[0])
+	}
+
+	rest, ok := strings.CutPrefix(string(line[:len(line)-2]), v1Prefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing PROXY prefix", ErrMalformed)
+	}
+	fields := strings.Split(rest, " ")
+	h := &Header{Version: 1, Command: CommandProxy}
+	if fields[0] == "UNKNOWN" {
+		// the receiver must ignore everything after UNKNOWN
+		return h, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("%w: invalid v1 fields", ErrMalformed)
+	}
+
+	src, err := parseV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(family, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (family == "TCP4") != (ip.To4() != nil && !strings.Contains(host, ":")) {
+		return nil, fmt.Errorf("%w: invalid %s address %q", ErrMalformed, family, host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrMalformed, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+const (
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+
+	v2HeaderLength = 16
+)
+
+func readV2(r io.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	fixed[0] = v2Signature[0]
+	if _, err := io.ReadFull(r, fixed[1:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if !bytes.Equal(fixed[:12], v2Signature) {
+		return nil, fmt.Errorf("%w: bad v2 signature", ErrMalformed)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMalformed, fixed[12]>>4)
+	}
+	h := &Header{Version: 2}
+	switch fixed[12] & 0x0f {
+	case 0x0:
+		h.Command = CommandLocal
+	case 0x1:
+		h.Command = CommandProxy
+	default:
+		return nil, fmt.Errorf("%w: unknown command %d", ErrMalformed, fixed[12]&0x0f)
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	var addrLen int
+	switch family {
+	case familyUnspec:
+	case familyInet:
+		addrLen = 12
+	case familyInet6:
+		addrLen = 36
+	case familyUnix:
+		addrLen = 216
+	default:
+		return nil, fmt.Errorf("%w: unknown address family %d", ErrMalformed, family)
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("%w: address block too short", ErrMalformed)
+	}
+
+	// addresses of LOCAL connections and non-stream transports are ignored
+	if h.Command == CommandProxy && transport == 0x1 {
+		switch family {
+		case familyInet:
+			h.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+			h.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+		case familyInet6:
+			h.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+			h.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+		case familyUnix:
+			h.Source = &net.UnixAddr{Net: "unix", Name: unixPath(payload[0:108])}
+			h.Destination = &net.UnixAddr{Net: "unix", Name: unixPath(payload[108:216])}
+		}
+	}
+
+	tlvs, offsets, err := parseTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	for i, tlv := range tlvs {
+		if tlv.Type == TLVCRC32C {
+			if err := checkCRC32C(fixed, payload, tlv.Value, addrLen+offsets[i]); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return h, nil
+}
+
+// parseTLVs also returns where in data each TLV's value starts.
+func parseTLVs(data []byte) ([]TLV, []int, error) {
+	var tlvs []TLV
+	var offsets []int
+	for pos := 0; pos < len(data); {
+		rest := data[pos:]
+		if len(rest) < 3 {
+			return nil, nil, fmt.Errorf("%w: truncated TLV", ErrMalformed)
+		}
+		length := int(binary.BigEndian.Uint16(rest[1:3]))
+		if len(rest) < 3+length {
+			return nil, nil, fmt.Errorf("%w: TLV longer than header", ErrMalformed)
+		}
+		tlvs = append(tlvs, TLV{Type: TLVType(rest[0]), Value: rest[3 : 3+length]})
+		offsets = append(offsets, pos+3)
+		pos += 3 + length
+	}
+	return tlvs, offsets, nil
+}
+
+// checkCRC32C verifies the checksum TLV value found at offset in payload,
+// it covers the whole header with the checksum itself zeroed.
+func checkCRC32C(fixed, payload, value []byte, offset int) error {
+	if len(value) != 4 {
+		return fmt.Errorf("%w: CRC32C TLV has %d bytes", ErrMalformed, len(value))
+	}
+	want := binary.BigEndian.Uint32(value)
+
+	zeroed := append([]byte(nil), payload...)
+	clear(zeroed[offset : offset+4])
+
+	table := crc32.MakeTable(crc32.Castagnoli)
+	sum := crc32.Update(crc32.Checksum(fixed, table), table, zeroed)
+	if sum != want {
+		return fmt.Errorf("%w: CRC32C mismatch", ErrMalformed)
+	}
+	return nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2Header builds a version 2 PROXY header for an IPv4 stream connection.
+func v2Header(command byte, tlvs []TLV, withCRC bool) []byte {
+	var payload bytes.Buffer
+	payload.Write(net.ParseIP("192.0.2.1").To4())
+	payload.Write(net.ParseIP("192.0.2.2").To4())
+	binary.Write(&payload, binary.BigEndian, uint16(56324))
+	binary.Write(&payload, binary.BigEndian, uint16(443))
+	for _, tlv := range tlvs {
+		payload.WriteByte(byte(tlv.Type))
+		binary.Write(&payload, binary.BigEndian, uint16(len(tlv.Value)))
+		payload.Write(tlv.Value)
+	}
+	if withCRC {
+		payload.Write([]byte{byte(TLVCRC32C), 0, 4, 0, 0, 0, 0})
+	}
+
+	var h bytes.Buffer
+	h.Write(v2Signature)
+	h.WriteByte(0x20 | command)
+	h.WriteByte(familyInet<<4 | 0x1)
+	binary.Write(&h, binary.BigEndian, uint16(payload.Len()))
+	h.Write(payload.Bytes())
+
+	out := h.Bytes()
+	if withCRC {
+		sum := crc32.Checksum(out, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(out[len(out)-4:], sum)
+	}
+	return out
+}
+
+func TestReadV1(t *testing.T) {
+	// Test: TCP4 leaves the rest of the stream unread
+	r := bytes.NewBufferString("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\n")
+	h, err := Read(r)
+	require.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, "192.0.2.1:56324", h.Source.String())
+	assert.Equal(t, "192.0.2.2:443", h.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", r.String())
+
+	// Test: TCP6
+	h, err = Read(bytes.NewBufferString("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	// Test: UNKNOWN carries no addresses
+	h, err = Read(bytes.NewBufferString("PROXY UNKNOWN ignored stuff\r\n"))
+	require.NoError(t, err)
+	assert.Nil(t, h.Source)
+
+	malformed := []string{
+		"GET / HTTP/1.1\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n",
+		"PROXY TCP6 192.0.2.1 2001:db8::2 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 0443 443\r\n",
+		"PROXY UDP4 192.0.2.1 192.0.2.2 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 1 2\n",
+		"PROXY " + string(bytes.Repeat([]byte("x"), 120)) + "\r\n",
+	}
+	for _, raw := range malformed {
+		_, err := Read(bytes.NewBufferString(raw))
+		assert.ErrorIs(t, err, ErrMalformed, raw)
+	}
+
+	// Test: Truncated header
+	_, err = Read(bytes.NewBufferString("PROXY TCP4 192.0.2.1"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestReadV2(t *testing.T) {
+	// Test: Addresses and TLVs
+	raw := append(v2Header(0x1, []TLV{
+		{Type: TLVAuthority, Value: []byte("example.com")},
+		{Type: TLVUniqueID, Value: []byte{1, 2, 3}},
+	}, true), "GET / HTTP/1.1\r\n"...)
+	r := bytes.NewBuffer(raw)
+	h, err := Read(r)
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, CommandProxy, h.Command)
+	assert.Equal(t, "192.0.2.1:56324", h.Source.String())
+	assert.Equal(t, "192.0.2.2:443", h.Destination.String())
+	authority, ok := h.TLV(TLVAuthority)
+	require.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	id, ok := h.TLV(TLVUniqueID)
+	require.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3}, id)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", r.String())
+
+	// Test: LOCAL ignores the addresses
+	h, err = Read(bytes.NewBuffer(v2Header(0x0, nil, false)))
+	require.NoError(t, err)
+	assert.Equal(t, CommandLocal, h.Command)
+	assert.Nil(t, h.Source)
+
+	// Test: Checksum mismatch
+	corrupt := v2Header(0x1, []TLV{{Type: TLVAuthority, Value: []byte("example.com")}}, true)
+	corrupt[20] ^= 0xff
+	_, err = Read(bytes.NewBuffer(corrupt))
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	// Test: TLV running past the header
+	bad := v2Header(0x1, []TLV{{Type: TLVNoop, Value: []byte("abc")}}, false)
+	binary.BigEndian.PutUint16(bad[len(bad)-5:], 10)
+	_, err = Read(bytes.NewBuffer(bad))
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	// Test: Unknown command
+	bad = v2Header(0x1, nil, false)
+	bad[12] = 0x22
+	_, err = Read(bytes.NewBuffer(bad))
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	// Test: Bad signature
+	bad = v2Header(0x1, nil, false)
+	bad[5] = 'X'
+	_, err = Read(bytes.NewBuffer(bad))
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	// Test: Truncated address block
+	_, err = Read(bytes.NewBuffer(v2Header(0x1, nil, false)[:20]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const defaultMaxKeys = 10000
+
+type Algorithm int
+
+const (
+	// TokenBucket allows bursts of up to Limit requests and refills Limit
+	// tokens per Window.
+	TokenBucket Algorithm = iota
+	// SlidingWindow allows Limit requests in any Window, estimated from the
+	// counts of the current and the previous fixed window.
+	SlidingWindow
+)
+
+type Policy struct {
+	Algorithm Algorithm
+	Limit     int
+	Window    time.Duration
+	// Key picks what the limit applies to, ByIP by default.
+	Key KeyFunc
+}
+
+// Decision is the outcome of taking one request from a limit.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the limit is fully available again.
+	Reset time.Duration
+	// RetryAfter is how long a denied client should wait.
+	RetryAfter time.Duration
+}
+
+// Limiter applies a policy to any number of keys. It keeps the state of at
+// most maxKeys keys and forgets the least recently used ones beyond that,
+// which at worst gives an evicted client a fresh limit.
+type Limiter struct {
+	policy  Policy
+	maxKeys int
+	now     func() time.Time
+
+	mu    sync.Mutex
+	keys  map[string]*list.Element
+	order *list.List
+}
+
+type entry struct {
+	key string
+	// tokens and last are the token bucket state
+	tokens float64
+	last   time.Time
+	// start, curr and prev are the sliding window state
+	start time.Time
+	curr  int
+	prev  int
+}
+
+// NewLimiter returns a limiter for policy, which must have a positive Limit
+// and Window. A non-positive maxKeys defaults to 10000.
+func NewLimiter(policy Policy, maxKeys int) *Limiter {
+	if policy.Limit <= 0 {
+		panic(fmt.Sprintf("ratelimit: non-positive Limit %d", policy.Limit))
+	}
+	if policy.Window <= 0 {
+		panic(fmt.Sprintf("ratelimit: non-positive Window %v", policy.Window))
+	}
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	return &Limiter{
+		policy:  policy,
+		maxKeys: maxKeys,
+		now:     time.Now,
+		keys:    make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Len returns the number of keys with state.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *Limiter) Allow(key string) Decision {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := l.entry(key, now)
+	if l.policy.Algorithm == SlidingWindow {
+		return l.slidingWindow(e, now)
+	}
+	return l.tokenBucket(e, now)
+}
+
+// entry returns the state of key, creating it and evicting the least
+// recently used key if needed.
+func (l *Limiter) entry(key string, now time.Time) *entry {
+	if el, ok := l.keys[key]; ok {
+		l.order.MoveToFront(el)
+		return el.Value.(*entry)
+	}
+	if l.order.Len() >= l.maxKeys {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.keys, oldest.Value.(*entry).key)
+	}
+	e := &entry{key: key, tokens: float64(l.policy.Limit), last: now, start: now}
+	l.keys[key] = l.order.PushFront(e)
+	return e
+}
+
+func (l *Limiter) tokenBucket(e *entry, now time.Time) Decision {
+	limit := float64(l.policy.Limit)
+	perSecond := limit / l.policy.Window.Seconds()
+
+	e.tokens = math.Min(limit, e.tokens+now.Sub(e.last).Seconds()*perSecond)
+	e.last = now
+
+	d := Decision{Limit: l.policy.Limit}
+	if e.tokens >= 1 {
+		e.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - e.tokens) / perSecond)
+	}
+	d.Remaining = int(e.tokens)
+	d.Reset = seconds((limit - e.tokens) / perSecond)
+	return d
+}
+
+func (l *Limiter) slidingWindow(e *entry, now time.Time) Decision {
+	window := l.policy.Window
+	if elapsed := now.Sub(e.start); elapsed >= window {
+		windows := elapsed / window
+		if windows == 1 {
+			e.prev = e.curr
+		} else {
+			e.prev = 0
+		}
+		e.curr = 0
+		e.start = e.start.Add(windows * window)
+	}
+
+	sinceStart := now.Sub(e.start)
+	prevWeight := 1 - float64(sinceStart)/float64(window)
+	estimate := float64(e.prev)*prevWeight + float64(e.curr)
+	limit := l.policy.Limit
+
+	d := Decision{Limit: limit, Reset: window - sinceStart}
+	if estimate+1 <= float64(limit) {
+		e.curr++
+		estimate++
+		d.Allowed = true
+	} else if e.prev > 0 && e.curr < limit {
+		// wait for enough of the previous window to slide out
+		excess := estimate + 1 - float64(limit)
+		d.RetryAfter = time.Duration(excess / float64(e.prev) * float64(window))
+	} else {
+		d.RetryAfter = window - sinceStart
+	}
+	d.Remaining = max(0, limit-int(math.Ceil(estimate)))
+	if e.prev > 0 {
+		// the previous window only stops counting one window from now
+		d.Reset = 2*window - sinceStart
+	}
+	return d
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+// KeyFunc picks the key a request is counted under. Requests with an empty
+// key are not limited.
+type KeyFunc func(req *request.Request) string
+
+// ByIP keys requests by the client IP, which is the one the forwarded
+// middleware resolved when it runs first.
+func ByIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// ByHeader keys requests by the value of the named header, like an API key.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		value, _ := req.Headers.Get(name)
+		return value
+	}
+}
+
+// ByRoute keys requests by method and path, sharing one limit among all
+// clients.
+func ByRoute(req *request.Request) string {
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return req.RequestLine.Method + " " + path
+}
+
+// Join keys requests by all of keys, like a limit per client and route.
+func Join(keys ...KeyFunc) KeyFunc {
+	return func(req *request.Request) string {
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			if parts[i] = key(req); parts[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(parts, "\x00")
+	}
+}
+
+type Route struct {
+	// Pattern matches the request path exactly, or as a prefix when it ends
+	// with a slash.
+	Pattern string
+	Policy  Policy
+}
+
+type Options struct {
+	// Default applies to requests no route matches, a zero Limit leaves them
+	// unlimited.
+	Default Policy
+	// Routes override Default, the longest matching pattern wins.
+	Routes []Route
+	// MaxKeys bounds the keys each policy tracks, defaults to 10000.
+	MaxKeys int
+}
+
+type route struct {
+	pattern string
+	limiter *Limiter
+	key     KeyFunc
+}
+
+// Limit returns a middleware that rejects requests over their policy's
+// limit with a 429. Every response carries RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers, and
+// rejections a Retry-After.
+func Limit(opts Options, next server.Handler) server.Handler {
+	routes := make([]route, 0, len(opts.Routes))
+	for _, r := range opts.Routes {
+		routes = append(routes, newRoute(r.Pattern, r.Policy, opts.MaxKeys))
+	}
+	fallback := newRoute("", opts.Default, opts.MaxKeys)
+
+	return func(w *response.Writer, req *request.Request) {
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		r := &fallback
+		for i := range routes {
+			if routes[i].matches(path) && len(routes[i].pattern) > len(r.pattern) {
+				r = &routes[i]
+			}
+		}
+		if r.limiter == nil {
+			next(w, req)
+			return
+		}
+		key := r.key(req)
+		if key == "" {
+			next(w, req)
+			return
+		}
+
+		d := r.limiter.Allow(key)
+		w.AddHeader("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.AddHeader("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.AddHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+		w.AddHeader("RateLimit-Policy", fmt.Sprintf("%d;w=%d", d.Limit, ceilSeconds(r.limiter.policy.Window)))
+		if !d.Allowed {
+			writeTooManyRequests(w, d)
+			return
+		}
+		next(w, req)
+	}
+}
+
+func newRoute(pattern string, policy Policy, maxKeys int) route {
+	r := route{pattern: pattern}
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		return r
+	}
+	r.limiter = NewLimiter(policy, maxKeys)
+	r.key = policy.Key
+	if r.key == nil {
+		r.key = ByIP
+	}
+	return r
+}
+
+func (r route) matches(path string) bool {
+	if strings.HasSuffix(r.pattern, "/") {
+		return strings.HasPrefix(path, r.pattern)
+	}
+	return path == r.pattern
+}
+
+func writeTooManyRequests(w *response.Writer, d Decision) {
+	body := "too many requests\n"
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(d.RetryAfter))))
+	w.WriteStatusLine(response.TooManyRequests)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
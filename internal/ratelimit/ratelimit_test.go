@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter(policy Policy, maxKeys int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := NewLimiter(policy, maxKeys)
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, clock := newTestLimiter(Policy{Algorithm: TokenBucket, Limit: 3, Window: 3 * time.Second}, 0)
+
+	// Test: Bursts up to the limit
+	for i := 2; i >= 0; i-- {
+		d := l.Allow("a")
+		require.True(t, d.Allowed)
+		assert.Equal(t, i, d.Remaining)
+	}
+	d := l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+	assert.Equal(t, 3*time.Second, d.Reset)
+
+	// Test: Keys are limited separately
+	assert.True(t, l.Allow("b").Allowed)
+
+	// Test: Refills one token per second
+	clock.Advance(time.Second)
+	assert.True(t, l.Allow("a").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+
+	// Test: Never refills over the limit
+	clock.Advance(time.Hour)
+	for range 3 {
+		assert.True(t, l.Allow("a").Allowed)
+	}
+	assert.False(t, l.Allow("a").Allowed)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	l, clock := newTestLimiter(Policy{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second}, 0)
+
+	for i := 3; i >= 0; i-- {
+		d := l.Allow("a")
+		require.True(t, d.Allowed)
+		assert.Equal(t, i, d.Remaining)
+	}
+	d := l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 10*time.Second, d.RetryAfter)
+
+	// Test: Half of the previous window still counts
+	clock.Advance(15 * time.Second)
+	d = l.Allow("a")
+	require.True(t, d.Allowed)
+	assert.Equal(t, 1, d.Remaining)
+	assert.True(t, l.Allow("a").Allowed)
+	d = l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 2500*time.Millisecond, d.RetryAfter)
+
+	// Test: The previous window slides out
+	clock.Advance(2500 * time.Millisecond)
+	assert.True(t, l.Allow("a").Allowed)
+
+	// Test: Idle for two windows starts over
+	clock.Advance(time.Minute)
+	for range 4 {
+		assert.True(t, l.Allow("a").Allowed)
+	}
+	assert.False(t, l.Allow("a").Allowed)
+}
+
+func TestLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	l, _ := newTestLimiter(Policy{Limit: 1, Window: time.Minute}, 2)
+
+	require.True(t, l.Allow("a").Allowed)
+	require.True(t, l.Allow("b").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+
+	// Test: "b" is the least recently used and is forgotten for "c"
+	require.True(t, l.Allow("c").Allowed)
+	assert.Equal(t, 2, l.Len())
+	assert.False(t, l.Allow("a").Allowed)
+	assert.True(t, l.Allow("b").Allowed)
+}
+
+func TestNewLimiterInvalidPolicy(t *testing.T) {
+	assert.PanicsWithValue(t, "ratelimit: non-positive Limit 0", func() {
+		NewLimiter(Policy{Window: time.Second}, 0)
+	})
+	assert.PanicsWithValue(t, "ratelimit: non-positive Window 0s", func() {
+		NewLimiter(Policy{Limit: 1}, 0)
+	})
+}
+
+// limitRequest runs handler for a request from addr and returns the parsed
+// response.
+func limitRequest(t *testing.T, handler func(*response.Writer, *request.Request), addr, target, headers string) *http.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n" + headers + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = addr
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+	w.Finish()
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func okHandler(w *response.Writer, req *request.Request) {
+	body := "ok\n"
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+}
+
+func TestLimit(t *testing.T) {
+	handler := Limit(Options{
+		Default: Policy{Limit: 2, Window: time.Minute},
+		Routes: []Route{
+			{Pattern: "/api/", Policy: Policy{Limit: 1, Window: time.Minute, Key: ByHeader("X-API-Key")}},
+			{Pattern: "/api/health", Policy: Policy{}},
+		},
+	}, okHandler)
+
+	// Test: Headers on allowed responses
+	resp := limitRequest(t, handler, "192.0.2.1:4000", "/", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", resp.Header.Get("RateLimit-Policy"))
+
+	// Test: The port does not matter for ByIP
+	resp = limitRequest(t, handler, "192.0.2.1:4001", "/other", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = limitRequest(t, handler, "192.0.2.1:4002", "/", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	resp = limitRequest(t, handler, "192.0.2.2:4000", "/", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Test: Route policies with their own key
+	resp = limitRequest(t, handler, "192.0.2.1:4000", "/api/users?page=2", "X-API-Key: one\r\n")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = limitRequest(t, handler, "192.0.2.3:4000", "/api/users", "X-API-Key: one\r\n")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp = limitRequest(t, handler, "192.0.2.1:4000", "/api/users", "X-API-Key: two\r\n")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Test: Requests without a key are not limited
+	for range 3 {
+		resp = limitRequest(t, handler, "192.0.2.1:4000", "/api/users", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+
+	// Test: A zero policy exempts a route
+	resp = limitRequest(t, handler, "192.0.2.1:4000", "/api/health", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+}
+
+func TestKeys(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("POST /a/b?c=d HTTP/1.1\r\nHost: localhost\r\nX-Tenant: acme\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "[2001:db8::1]:4000"
+
+	assert.Equal(t, "2001:db8::1", ByIP(req))
+	assert.Equal(t, "acme", ByHeader("x-tenant")(req))
+	assert.Equal(t, "POST /a/b", ByRoute(req))
+	assert.Equal(t, "acme\x00POST /a/b", Join(ByHeader("X-Tenant"), ByRoute)(req))
+	assert.Empty(t, Join(ByHeader("X-Missing"), ByRoute)(req))
+}
@@ -0,0 +1,234 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	bufferSize    = 4096
+	maxPooledSize = 64 * 1024
+	// bodyRateGrace is how long a body may lag behind Limits.MinBodyRate
+	// before the rate is enforced.
+	bodyRateGrace = 5 * time.Second
+)
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
+
+// Reader parses consecutive requests from a connection. Bytes read past the
+// end of one request are kept for the next ReadRequest call, which is what
+// makes keep-alive and pipelining work.
+// The read buffer comes from a shared pool and is only held while there are
+// unconsumed bytes, so idle connections don't pin memory.
+type Reader struct {
+	src    io.Reader
+	buf    []byte
+	n      int
+	limits Limits
+}
+
+// Limits protect the reader from clients that send huge or slow requests.
+// Zero values mean no limit. The timeouts only apply when the source has a
+// SetReadDeadline method, like a net.Conn.
+type Limits struct {
+	// MaxRequestLineSize caps the request line including its CRLF.
+	MaxRequestLineSize int
+	// MaxHeaderSize caps the header section including the empty line that
+	// ends it.
+	MaxHeaderSize int
+	// HeaderTimeout is how long ReadRequest waits for the request line and
+	// headers. It also bounds how long an idle connection waits for its
+	// next request, which then fails with the deadline error rather than a
+	// ParseError.
+	HeaderTimeout time.Duration
+	// MinBodyRate is the slowest a body may arrive in bytes per second,
+	// enforced once the body is 5 seconds old.
+	MinBodyRate int
+}
+
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src}
+}
+
+// SetLimits sets the limits the following ReadRequest calls enforce.
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// Buffered returns the number of bytes already read from the connection that
+// belong to the next request.
+func (r *Reader) Buffered() int {
+	return r.n
+}
+
+// ReadRequest parses the next request. If the connection is closed before any
+// byte of a request arrives, io.EOF is returned.
+func (r *Reader) ReadRequest() (*Request, error) {
+	if r.buf == nil {
+		r.buf = *bufferPool.Get().(*[]byte)
+	}
+	defer func() {
+		if r.n == 0 {
+			r.Release()
+		}
+	}()
+
+	request := newRequest()
+
+	d, _ := r.src.(deadliner)
+	if r.limits.HeaderTimeout <= 0 && r.limits.MinBodyRate <= 0 {
+		d = nil
+	}
+	if d != nil {
+		if r.limits.HeaderTimeout > 0 {
+			d.SetReadDeadline(time.Now().Add(r.limits.HeaderTimeout))
+		}
+		defer d.SetReadDeadline(time.Time{})
+	}
+	var bodyStart time.Time
+
+	if r.n > 0 {
+		if err := r.consume(request); err != nil {
+			return nil, err
+		}
+	}
+
+	for request.ParserState != StateDone {
+		if d != nil && request.ParserState == StateParsingBody {
+			if bodyStart.IsZero() {
+				bodyStart = time.Now()
+				d.SetReadDeadline(time.Time{})
+			}
+			if r.limits.MinBodyRate > 0 {
+				allowed := bodyRateGrace + time.Duration(request.BodyLengthRead)*time.Second/time.Duration(r.limits.MinBodyRate)
+				d.SetReadDeadline(bodyStart.Add(allowed))
+			}
+		}
+
+		bufLen := len(r.buf)
+		if r.n == bufLen {
+			newBuf := make([]byte, bufLen*2)
+			copy(newBuf, r.buf)
+			r.buf = newBuf
+		}
+
+		n, err := r.src.Read(r.buf[r.n:])
+		r.n += n
+		if n > 0 {
+			if err := r.consume(request); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			if request.ParserState == StateInitialized && r.n == 0 {
+				return nil, io.EOF
+			}
+			if request.ParserState != StateDone {
+				return nil, &ParseError{Kind: KindIncomplete, Err: fmt.Errorf("incomplete request in state: %d", request.ParserState)}
+			}
+
+			break
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && (request.ParserState != StateInitialized || r.n > 0) {
+			return nil, &ParseError{Kind: KindTimeout, Err: err}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if request.ParserState != StateDone {
+		return nil, &ParseError{Kind: KindIncomplete, Err: errors.New("incomplete request")}
+	}
+
+	return request, nil
+}
+
+// ReadAhead does a single read from the connection into the buffer, for the
+// server to notice a client closing the connection while a request is
+// handled. The bytes read are parsed by the next ReadRequest. It must not
+// be called concurrently with other Reader methods.
+func (r *Reader) ReadAhead() error {
+	if r.buf == nil {
+		r.buf = *bufferPool.Get().(*[]byte)
+	}
+	if r.n == len(r.buf) {
+		return nil
+	}
+	n, err := r.src.Read(r.buf[r.n:])
+	r.n += n
+	if n > 0 {
+		return nil
+	}
+	if r.n == 0 {
+		r.Release()
+	}
+	return err
+}
+
+// Detach returns a copy of the buffered bytes and releases the buffer, for
+// when the connection stops carrying HTTP requests.
+func (r *Reader) Detach() []byte {
+	var buffered []byte
+	if r.n > 0 {
+		buffered = make([]byte, r.n)
+		copy(buffered, r.buf[:r.n])
+	}
+	r.Release()
+	return buffered
+}
+
+// Release returns the read buffer to the pool, dropping any buffered bytes.
+func (r *Reader) Release() {
+	if r.buf == nil {
+		return
+	}
+	if cap(r.buf) <= maxPooledSize {
+		buf := r.buf[:cap(r.buf)]
+		bufferPool.Put(&buf)
+	}
+	r.buf = nil
+	r.n = 0
+}
+
+func (r *Reader) consume(request *Request) error {
+	numberOfParsedBytes, err := request.parse(r.buf[:r.n])
+	if err != nil {
+		return err
+	}
+	copy(r.buf, r.buf[numberOfParsedBytes:r.n])
+	r.n -= numberOfParsedBytes
+	return r.checkSize(request)
+}
+
+// checkSize enforces the size limits on the parsed part of the request and
+// on the unparsed rest of the line it is waiting for.
+func (r *Reader) checkSize(request *Request) error {
+	lineSize, headerSize := request.lineSize, request.headerSize
+	switch request.ParserState {
+	case StateInitialized:
+		lineSize += r.n
+	case StateParsingHeaders:
+		headerSize += r.n
+	}
+	if max := r.limits.MaxRequestLineSize; max > 0 && lineSize > max {
+		return &ParseError{Kind: KindRequestLineTooLong, Err: fmt.Errorf("request line longer than %d bytes", max)}
+	}
+	if max := r.limits.MaxHeaderSize; max > 0 && headerSize > max {
+		return &ParseError{Kind: KindHeaderTooLarge, Err: fmt.Errorf("header section larger than %d bytes", max)}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderPipelined(t *testing.T) {
+	// Test: Two pipelined requests in one read
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 1024,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Equal(t, len("GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"), reader.Buffered())
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "localhost:42069", r.Headers["host"])
+	assert.Equal(t, 0, reader.Buffered())
+	assert.Nil(t, reader.buf)
+
+	// Test: Clean EOF between requests
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Requests split across small reads
+	reader = NewReader(&chunkReader{
+		data: "GET /a HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	for _, target := range []string{"/a", "/b"} {
+		r, err = reader.ReadRequest()
+		require.NoError(t, err)
+		require.NotNil(t, r)
+		assert.Equal(t, target, r.RequestLine.RequestTarget)
+		assert.Equal(t, "localhost:42069", r.Headers["host"])
+	}
+
+	// Test: Truncated second request
+	reader = NewReader(&chunkReader{
+		data:            "GET /a HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\nHost",
+		numBytesPerRead: 1024,
+	})
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+	reader.Release()
+	assert.Equal(t, 0, reader.Buffered())
+}
+
+func TestReaderSizeLimits(t *testing.T) {
+	limits := Limits{MaxRequestLineSize: 32, MaxHeaderSize: 64}
+	tests := map[string]string{
+		"GET /" + strings.Repeat("a", 40) + " HTTP/1.1\r\n\r\n":             KindRequestLineTooLong,
+		"GET /" + strings.Repeat("a", 40):                                   KindRequestLineTooLong,
+		"GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 60) + "\r\n\r\n": KindHeaderTooLarge,
+		"GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 60):              KindHeaderTooLarge,
+	}
+	for raw, kind := range tests {
+		for _, perRead := range []int{3, 1024} {
+			reader := NewReader(&chunkReader{data: raw, numBytesPerRead: perRead})
+			reader.SetLimits(limits)
+			_, err := reader.ReadRequest()
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr, raw)
+			assert.Equal(t, kind, parseErr.Kind, raw)
+			reader.Release()
+		}
+	}
+
+	// Test: Requests at the limits and bodies of any size pass
+	raw := "POST /" + strings.Repeat("a", 15) + " HTTP/1.1\r\n" +
+		"X-Long: " + strings.Repeat("a", 31) + "\r\n" +
+		"Content-Length: 100\r\n" +
+		"\r\n"
+	reader := NewReader(&chunkReader{data: raw + strings.Repeat("b", 100), numBytesPerRead: 5})
+	reader.SetLimits(limits)
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, 100, len(r.Body))
+	assert.Equal(t, 32, r.lineSize)
+	assert.Equal(t, 64, r.headerSize)
+}
+
+func TestReaderTimeouts(t *testing.T) {
+	newPipe := func(limits Limits) (*Reader, net.Conn) {
+		server, client := net.Pipe()
+		t.Cleanup(func() {
+			server.Close()
+			client.Close()
+		})
+		reader := NewReader(server)
+		reader.SetLimits(limits)
+		return reader, client
+	}
+
+	// Test: A partial head times out
+	reader, client := newPipe(Limits{HeaderTimeout: 50 * time.Millisecond})
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: loc"))
+	_, err := reader.ReadRequest()
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, KindTimeout, parseErr.Kind)
+
+	// Test: An idle connection fails with the bare deadline error
+	reader, _ = newPipe(Limits{HeaderTimeout: 50 * time.Millisecond})
+	_, err = reader.ReadRequest()
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.False(t, errors.As(err, &parseErr))
+
+	// Test: The header timeout does not apply to the body
+	reader, client = newPipe(Limits{HeaderTimeout: 50 * time.Millisecond})
+	go func() {
+		client.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 2\r\n\r\n"))
+		time.Sleep(100 * time.Millisecond)
+		client.Write([]byte("ok"))
+	}()
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(r.Body))
+}
@@ -1,9 +1,12 @@
 package request
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 
@@ -16,6 +19,47 @@ type Request struct {
 	Headers        headers.Headers
 	Body           []byte
 	BodyLengthRead int
+
+	// The fields below are set by the server.
+
+	// RemoteAddr and LocalAddr are the "host:port" addresses of the client
+	// and of the server side of the connection.
+	RemoteAddr string
+	LocalAddr  string
+	// TLS is the state of the TLS connection, nil for plain HTTP.
+	TLS *tls.ConnectionState
+	// ConnID identifies the connection within the server, starting at 1.
+	ConnID uint64
+	// Sequence numbers the requests on a connection, starting at 1.
+	Sequence int
+
+	ctx context.Context
+	// lineSize and headerSize count the bytes of the request line and of
+	// the header section parsed so far, for the reader's Limits.
+	lineSize   int
+	headerSize int
+}
+
+// Context returns the request's context. For requests served by the server
+// it is cancelled when the client goes away, the server shuts down, the
+// request times out or the handler returns.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx,
+// e.g. for middleware to attach values with context.WithValue before
+// calling the next handler.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
 }
 
 type RequestLine struct {
@@ -39,55 +83,52 @@ const (
 	StateDone
 )
 
-const bufferSize = 8
+// Kinds of ParseError.
+const (
+	KindRequestLine   = "request_line"
+	KindHeader        = "header"
+	KindContentLength = "content_length"
+	KindIncomplete    = "incomplete"
+	// KindRequestLineTooLong, KindHeaderTooLarge and KindTimeout mark
+	// requests that trip the reader's Limits.
+	KindRequestLineTooLong = "request_line_too_long"
+	KindHeaderTooLarge     = "header_too_large"
+	KindTimeout            = "timeout"
+	// KindTransferEncoding marks requests with a Transfer-Encoding, whose
+	// bodies the parser can't frame.
+	KindTransferEncoding = "transfer_encoding"
+)
+
+// ParseError is returned when the bytes a client sent aren't a valid
+// request, as opposed to errors reading them from the connection.
+type ParseError struct {
+	// Kind is one of KindRequestLine, KindHeader, KindContentLength,
+	// KindIncomplete, KindRequestLineTooLong, KindHeaderTooLarge,
+	// KindTimeout or KindTransferEncoding.
+	Kind string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	r := NewReader(reader)
+	defer r.Release()
+	return r.ReadRequest()
+}
 
-	request := &Request{
+func newRequest() *Request {
+	return &Request{
 		ParserState: StateInitialized,
 		Headers:     headers.NewHeaders(),
 		Body:        make([]byte, 0),
 	}
-
-	for request.ParserState != StateDone {
-		bufLen := len(buf)
-		if readToIndex == bufLen {
-			newBuf := make([]byte, bufLen*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
-
-		n, err := reader.Read(buf[readToIndex:])
-		if err == io.EOF {
-			if request.ParserState != StateDone {
-				return nil, fmt.Errorf("incomplete request in state: %d", request.ParserState)
-			}
-
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-
-		readToIndex += n
-		numberOfParsedBytes, err := request.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
-		}
-		if numberOfParsedBytes == 0 {
-			continue
-		}
-		copy(buf, buf[numberOfParsedBytes:readToIndex])
-		readToIndex -= numberOfParsedBytes
-	}
-
-	if request.ParserState != StateDone {
-		return nil, errors.New("incomplete request")
-	}
-
-	return request, nil
 }
 
 func parseRequestLine(line string) (*RequestLine, int, error) {
@@ -95,20 +136,80 @@ func parseRequestLine(line string) (*RequestLine, int, error) {
 	if idx == -1 {
 		return nil, 0, nil
 	}
-	parts := strings.Split(line, "\r\n")
-	requestLineParts := strings.Split(parts[0], " ")
+	requestLineParts := strings.Split(line[:idx], " ")
 	if len(requestLineParts) <= 2 {
 		return nil, 0, errors.New("missing part")
 	}
-	httpVersionParts := requestLineParts[2]
-	httpVersion := strings.Split(httpVersionParts, "/")
+	if len(requestLineParts) > 3 {
+		return nil, 0, errors.New("too many parts in request line")
+	}
+	method, target, version := requestLineParts[0], requestLineParts[1], requestLineParts[2]
+
+	if !methodIsValid(method) {
+		return nil, 0, fmt.Errorf("invalid method: %s", method)
+	}
+	httpVersion, ok := strings.CutPrefix(version, "HTTP/")
+	if !ok || (httpVersion != "1.1" && httpVersion != "1.0") {
+		return nil, 0, fmt.Errorf("unsupported http version: %s", version)
+	}
+	if err := validateTarget(method, target); err != nil {
+		return nil, 0, err
+	}
+
 	return &RequestLine{
-		Method:        requestLineParts[0],
-		RequestTarget: requestLineParts[1],
-		HttpVersion:   httpVersion[1],
+		Method:        method,
+		RequestTarget: target,
+		HttpVersion:   httpVersion,
 	}, idx + 2, nil
 }
 
+func methodIsValid(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, char := range method {
+		if char < 'A' || char > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTarget checks the target has the form RFC 9112 allows for method:
+// authority-form for CONNECT, asterisk-form for OPTIONS, otherwise
+// origin-form or absolute-form.
+func validateTarget(method, target string) error {
+	switch {
+	case method == "CONNECT":
+		_, _, err := SplitAuthority(target)
+		return err
+	case strings.HasPrefix(target, "/"):
+		return nil
+	case target == "*" && method == "OPTIONS":
+		return nil
+	case strings.Contains(target, "://"):
+		return nil
+	}
+	return fmt.Errorf("invalid request target: %s", target)
+}
+
+// SplitAuthority splits an authority-form target like "example.com:443"
+// into its host and port, both of which are required.
+func SplitAuthority(target string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(target)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid authority %q: %w", target, err)
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("invalid authority %q: missing host", target)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return "", "", fmt.Errorf("invalid authority %q: bad port", target)
+	}
+	return host, port, nil
+}
+
 // Read reads up to len(p) or numBytesPerRead bytes from the string per call
 // its useful for simulating reading a variable number of bytes per chunk from a network connection
 func (cr *chunkReader) Read(p []byte) (n int, err error) {
@@ -144,25 +245,44 @@ func (r *Request) parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
+// checkFraming refuses bodies framed by Transfer-Encoding. Parsing them by
+// Content-Length, or not at all, would leave the body to be read as the
+// next request on the connection, which is how requests get smuggled past
+// proxies.
+func (r *Request) checkFraming() error {
+	if _, ok := r.Headers.Get("Transfer-Encoding"); !ok {
+		return nil
+	}
+	if _, ok := r.Headers.Get("Content-Length"); ok {
+		return &ParseError{Kind: KindContentLength, Err: errors.New("both Transfer-Encoding and Content-Length are set")}
+	}
+	return &ParseError{Kind: KindTransferEncoding, Err: errors.New("Transfer-Encoding is not supported")}
+}
+
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.ParserState {
 	case StateInitialized:
 		parsedRequestLine, numberOfBytes, err := parseRequestLine(string(data))
 		if err != nil {
-			return 0, err
+			return 0, &ParseError{Kind: KindRequestLine, Err: err}
 		}
 		if numberOfBytes == 0 {
 			return 0, nil
 		}
 		r.RequestLine = *parsedRequestLine
+		r.lineSize = numberOfBytes
 		r.ParserState = StateParsingHeaders
 		return numberOfBytes, nil
 	case StateParsingHeaders:
 		numberOfBytes, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, err
+			return 0, &ParseError{Kind: KindHeader, Err: err}
 		}
+		r.headerSize += numberOfBytes
 		if done {
+			if err := r.checkFraming(); err != nil {
+				return 0, err
+			}
 			r.ParserState = StateParsingBody
 		}
 		return numberOfBytes, nil
@@ -170,23 +290,22 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		contentLengthStr, ok := r.Headers.Get("Content-Length")
 		if !ok {
 			r.ParserState = StateDone
-			return len(data), nil
+			return 0, nil
 		}
 		contentLengthInt, err := strconv.Atoi(contentLengthStr)
-		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length value: %s", contentLengthStr)
+		if err != nil || contentLengthInt < 0 {
+			return 0, &ParseError{Kind: KindContentLength, Err: fmt.Errorf("invalid Content-Length value: %s", contentLengthStr)}
 		}
 
-		r.Body = append(r.Body, data...)
-		r.BodyLengthRead += len(data)
+		// anything past Content-Length belongs to the next pipelined request
+		n := min(contentLengthInt-r.BodyLengthRead, len(data))
+		r.Body = append(r.Body, data[:n]...)
+		r.BodyLengthRead += n
 
-		if r.BodyLengthRead > contentLengthInt {
-			return 0, errors.New("body is greater than the Content-Length header")
-		}
 		if r.BodyLengthRead == contentLengthInt {
 			r.ParserState = StateDone
 		}
-		return len(data), nil
+		return n, nil
 	case StateDone:
 		return 0, errors.New("trying read data in done state")
 	default:
@@ -144,3 +144,80 @@ func TestParseBody(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
 }
+
+func TestRequestTargetForms(t *testing.T) {
+	// Test: Authority-form CONNECT
+	reader := &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+
+	// Test: CONNECT without a port
+	reader = &chunkReader{
+		data:            "CONNECT example.com HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Origin-form target with CONNECT
+	reader = &chunkReader{
+		data:            "CONNECT / HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Asterisk-form OPTIONS
+	reader = &chunkReader{
+		data:            "OPTIONS * HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.RequestLine.RequestTarget)
+
+	// Test: Invalid http version
+	reader = &chunkReader{
+		data:            "GET / 1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestSplitAuthority(t *testing.T) {
+	host, port, err := SplitAuthority("[::1]:8080")
+	require.NoError(t, err)
+	assert.Equal(t, "::1", host)
+	assert.Equal(t, "8080", port)
+
+	_, _, err = SplitAuthority(":443")
+	assert.Error(t, err)
+	_, _, err = SplitAuthority("example.com:http")
+	assert.Error(t, err)
+	_, _, err = SplitAuthority("example.com:70000")
+	assert.Error(t, err)
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	tests := map[string]string{
+		"GET / HTTP/2.0\r\n\r\n":                                                              KindRequestLine,
+		"GET / HTTP/1.1\r\nHost : localhost\r\n\r\n":                                          KindHeader,
+		"POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n":                                       KindContentLength,
+		"POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\nab":                                     KindIncomplete,
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n":                      KindTransferEncoding,
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n0\r\n\r\n": KindContentLength,
+	}
+	for raw, kind := range tests {
+		_, err := RequestFromReader(&chunkReader{data: raw, numBytesPerRead: 3})
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, raw)
+		assert.Equal(t, kind, parseErr.Kind, raw)
+	}
+}
@@ -1,9 +1,12 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
 	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
 )
@@ -11,9 +14,24 @@ import (
 type StatusCode int
 
 const (
+	SwitchingProtocols  StatusCode = 101
 	OK                  StatusCode = 200
 	BadRequest          StatusCode = 400
+	Forbidden           StatusCode = 403
+	NotAcceptable       StatusCode = 406
+	ProxyAuthRequired   StatusCode = 407
+	RequestTimeout      StatusCode = 408
+	PayloadTooLarge     StatusCode = 413
+	URITooLong          StatusCode = 414
+	UnsupportedMedia    StatusCode = 415
+	UnprocessableEntity StatusCode = 422
+	UpgradeRequired     StatusCode = 426
+	TooManyRequests     StatusCode = 429
+	HeaderTooLarge      StatusCode = 431
 	InternalServerError StatusCode = 500
+	NotImplemented      StatusCode = 501
+	BadGateway          StatusCode = 502
+	ServiceUnavailable  StatusCode = 503
 )
 
 type WriterState int
@@ -23,11 +41,34 @@ const (
 	stateStatusLineWritten
 	stateHeadersWritten
 	stateBodyWritten
+	stateTrailersWritten
+	stateHijacked
 )
 
+// BodyEncoder is consulted when the headers are written. It may change the
+// headers and returns a constructor for the stream the body is written
+// through, or nil to send the body as is. Encoded bodies are always sent
+// chunked. If the stream has a Flush method it is flushed after every
+// WriteChunkedBody, so streamed bodies aren't held back by the encoder.
+type BodyEncoder func(statusCode StatusCode, h headers.Headers) func(io.Writer) io.WriteCloser
+
 type Writer struct {
-	w     io.Writer
-	state WriterState
+	w      io.Writer
+	state  WriterState
+	status StatusCode
+
+	conn     net.Conn
+	buffered func() []byte
+
+	chunked       bool
+	contentLength int
+	bodyLen       int
+	closeAfter    bool
+
+	encoder BodyEncoder
+	enc     io.WriteCloser
+
+	extraHeaders []string
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -37,6 +78,18 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+// NewConnWriter returns a Writer for a server connection that can be
+// hijacked. buffered is called on Hijack to collect the bytes already read
+// from conn that no request consumed.
+func NewConnWriter(conn net.Conn, buffered func() []byte) *Writer {
+	return &Writer{
+		w:        conn,
+		state:    stateInitial,
+		conn:     conn,
+		buffered: buffered,
+	}
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.state != stateInitial {
 		return fmt.Errorf("status line only can be written in initial state")
@@ -52,26 +105,61 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	}
 
 	w.state = stateStatusLineWritten
+	w.status = statusCode
 
 	return nil
 }
 
+// StatusCode returns the status written so far, or 0 before the status line.
+func (w *Writer) StatusCode() StatusCode {
+	return w.status
+}
+
+// BodyBytes returns how many body bytes were sent so far, after any body
+// encoding and without chunk framing.
+func (w *Writer) BodyBytes() int {
+	return w.bodyLen
+}
+
+// SetBodyEncoder installs encoder for this response, it has to be called
+// before the headers are written.
+func (w *Writer) SetBodyEncoder(encoder BodyEncoder) {
+	w.encoder = encoder
+}
+
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	headers := headers.NewHeaders()
 	contentLenStr := strconv.Itoa(contentLen)
 
 	headers.Set("Content-Length", contentLenStr)
 	headers.Set("Content-Type", "text/plain")
-	headers.Set("Connection", "close")
 
 	return headers
 }
 
+// AddHeader queues a header written on its own line by WriteHeaders, for
+// fields like Set-Cookie that can't be comma-joined by headers.Set.
+func (w *Writer) AddHeader(key, value string) error {
+	if w.state != stateInitial && w.state != stateStatusLineWritten {
+		return fmt.Errorf("headers already written")
+	}
+	w.extraHeaders = append(w.extraHeaders, buildHeaderString(strings.ToLower(key), value))
+	return nil
+}
+
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.state != stateStatusLineWritten {
 		return fmt.Errorf("headers only can be written after status line")
 	}
 
+	if w.encoder != nil {
+		if newEncoder := w.encoder(w.status, headers); newEncoder != nil {
+			headers.Delete("Content-Length")
+			headers.Override("Transfer-Encoding", "chunked")
+			w.enc = newEncoder(chunkWriter{w})
+		}
+	}
+	w.inspectHeaders(headers)
 	for key, value := range headers {
 		headerStr := buildHeaderString(key, value)
 		_, err := w.Write([]byte(headerStr))
@@ -79,6 +167,12 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 			return err
 		}
 	}
+	for _, headerStr := range w.extraHeaders {
+		_, err := w.Write([]byte(headerStr))
+		if err != nil {
+			return err
+		}
+	}
 	_, err := w.Write([]byte("\r\n"))
 	if err != nil {
 		return err
@@ -93,14 +187,34 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.state != stateHeadersWritten {
 		return 0, fmt.Errorf("body only can be written after headers")
 	}
-	return w.Write(p)
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	n, err := w.Write(p)
+	w.bodyLen += n
+	return n, err
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != stateHeadersWritten {
 		return 0, fmt.Errorf("cannot write body in state %d", w.state)
 	}
+	if w.enc != nil {
+		n, err := w.enc.Write(p)
+		if err != nil {
+			return n, err
+		}
+		if f, ok := w.enc.(interface{ Flush() error }); ok {
+			err = f.Flush()
+		}
+		return n, err
+	}
+	return w.writeChunk(p)
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	chunkSize := len(p)
+	w.bodyLen += chunkSize
 
 	nTotal := 0
 	n, err := fmt.Fprintf(w.w, "%x\r\n", chunkSize)
@@ -127,6 +241,13 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.state != stateHeadersWritten {
 		return 0, fmt.Errorf("cannot write body in state %d", w.state)
 	}
+	if w.enc != nil {
+		err := w.enc.Close()
+		w.enc = nil
+		if err != nil {
+			return 0, err
+		}
+	}
 	n, err := w.Write([]byte("0\r\n"))
 	if err != nil {
 		return n, err
@@ -139,7 +260,7 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.state != stateBodyWritten {
 		return fmt.Errorf("cannot write trailers in state %d", w.state)
 	}
-	defer func() { w.state = stateBodyWritten }()
+	defer func() { w.state = stateTrailersWritten }()
 	for k, v := range h {
 		_, err := w.Write([]byte(fmt.Sprintf("%s: %s\r\n", k, v)))
 		if err != nil {
@@ -150,22 +271,149 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	return err
 }
 
+// Finish terminates a chunked body the handler left open so the next
+// response on the connection starts on a message boundary.
+func (w *Writer) Finish() error {
+	if !w.chunked {
+		return nil
+	}
+	switch w.state {
+	case stateHeadersWritten:
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		fallthrough
+	case stateBodyWritten:
+		_, err := w.Write([]byte("\r\n"))
+		w.state = stateTrailersWritten
+		return err
+	}
+	return nil
+}
+
+// ShouldClose reports whether the connection has to be closed after this
+// response, either because it asked for it or because its end can't be
+// determined by the client.
+func (w *Writer) ShouldClose() bool {
+	if w.state == stateHijacked {
+		return true
+	}
+	if w.state == stateInitial || w.state == stateStatusLineWritten {
+		return true
+	}
+	if w.closeAfter {
+		return true
+	}
+	return !w.chunked && w.bodyLen != w.contentLength
+}
+
+// Hijack takes over the connection the response is written to, e.g. to
+// speak another protocol after a 101 Switching Protocols. It also returns
+// the bytes the client already sent past the current request.
+// After Hijack the server neither writes to nor closes the connection, that
+// is up to the caller.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.conn == nil {
+		return nil, nil, errors.New("writer is not backed by a connection")
+	}
+	if w.state == stateHijacked {
+		return nil, nil, errors.New("connection already hijacked")
+	}
+	w.state = stateHijacked
+
+	var buffered []byte
+	if w.buffered != nil {
+		buffered = w.buffered()
+	}
+	return w.conn, buffered, nil
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.state == stateHijacked
+}
+
+func (w *Writer) inspectHeaders(h headers.Headers) {
+	if headers.HasToken(h, "Connection", "close") {
+		w.closeAfter = true
+	}
+	if headers.HasToken(h, "Transfer-Encoding", "chunked") {
+		w.chunked = true
+		return
+	}
+	v, ok := h.Get("Content-Length")
+	if !ok {
+		w.closeAfter = true
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		w.closeAfter = true
+		return
+	}
+	w.contentLength = n
+}
+
 func (w *Writer) Write(p []byte) (int, error) {
 	return w.w.Write(p)
 }
 
+// chunkWriter frames everything an encoder produces as body chunks.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := c.w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func buildHeaderString(key, value string) string {
 	return fmt.Sprintf("%s: %s\r\n", key, value)
 }
 
 func reasonPhrase(statusCode StatusCode) string {
 	switch statusCode {
+	case SwitchingProtocols:
+		return "Switching Protocols"
 	case OK:
 		return "OK"
 	case BadRequest:
 		return "Bad Request"
+	case Forbidden:
+		return "Forbidden"
+	case NotAcceptable:
+		return "Not Acceptable"
+	case ProxyAuthRequired:
+		return "Proxy Authentication Required"
+	case RequestTimeout:
+		return "Request Timeout"
+	case PayloadTooLarge:
+		return "Payload Too Large"
+	case URITooLong:
+		return "URI Too Long"
+	case UnsupportedMedia:
+		return "Unsupported Media Type"
+	case UnprocessableEntity:
+		return "Unprocessable Entity"
+	case UpgradeRequired:
+		return "Upgrade Required"
+	case TooManyRequests:
+		return "Too Many Requests"
+	case HeaderTooLarge:
+		return "Request Header Fields Too Large"
 	case InternalServerError:
 		return "Internal Server Error"
+	case NotImplemented:
+		return "Not Implemented"
+	case BadGateway:
+		return "Bad Gateway"
+	case ServiceUnavailable:
+		return "Service Unavailable"
 	}
 	return ""
 }
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingListener returns errs from Accept before accepting from the
+// wrapped listener, or fails forever with err if it is set.
+type failingListener struct {
+	net.Listener
+
+	mu    sync.Mutex
+	errs  []error
+	err   error
+	calls int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.calls++
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.mu.Unlock()
+		return nil, err
+	}
+	err := l.err
+	l.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return l.Listener.Accept()
+}
+
+func (l *failingListener) Calls() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls
+}
+
+func newFailingListener(t *testing.T, errs ...error) *failingListener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return &failingListener{Listener: listener, errs: errs}
+}
+
+// errorRecorder collects accept errors from several goroutines.
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *errorRecorder) record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *errorRecorder) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errs...)
+}
+
+func acceptError(errno syscall.Errno) error {
+	return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", errno)}
+}
+
+func TestServerAcceptBackoff(t *testing.T) {
+	// Test: Temporary errors are retried with growing delays
+	listener := newFailingListener(t, acceptError(syscall.EMFILE), acceptError(syscall.ENFILE), acceptError(syscall.ENOBUFS))
+	var logs bytes.Buffer
+	var recorder errorRecorder
+	start := time.Now()
+	s, err := ServeListener(listener, echoTargetHandler,
+		WithErrorLog(log.New(&logs, "", 0)),
+		WithAcceptErrorHandler(recorder.record))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusOK, roundTrip(t, conn, "GET /a HTTP/1.1\r\n\r\n"))
+	assert.GreaterOrEqual(t, time.Since(start), minAcceptDelay+2*minAcceptDelay+4*minAcceptDelay)
+	require.NoError(t, s.Close())
+
+	errs := recorder.Errors()
+	require.Len(t, errs, 3)
+	assert.ErrorIs(t, errs[0], syscall.EMFILE)
+	assert.Contains(t, logs.String(), "too many open files; retrying in 5ms")
+	assert.Contains(t, logs.String(), "retrying in 20ms")
+}
+
+func TestServerAcceptPermanentError(t *testing.T) {
+	// Test: Other errors stop the accept loop
+	permanent := errors.New("listener broke")
+	listener := newFailingListener(t)
+	listener.err = permanent
+	var logs bytes.Buffer
+	var recorder errorRecorder
+	s, err := ServeListener(listener, echoTargetHandler,
+		WithErrorLog(log.New(&logs, "", 0)),
+		WithAcceptErrorHandler(recorder.record))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return len(recorder.Errors()) == 1 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, listener.Calls())
+	require.NoError(t, s.Close())
+	assert.Equal(t, []error{permanent}, recorder.Errors())
+	assert.Contains(t, logs.String(), "listener broke; no longer accepting connections")
+}
+
+func TestServerCloseDuringBackoff(t *testing.T) {
+	// Test: Close doesn't wait for the backoff to run out
+	listener := newFailingListener(t)
+	listener.err = acceptError(syscall.EMFILE)
+	s, err := ServeListener(listener, echoTargetHandler, WithErrorLog(log.New(io.Discard, "", 0)))
+	require.NoError(t, err)
+
+	// let the delay grow to its maximum
+	assert.Eventually(t, func() bool { return listener.Calls() >= 9 }, 5*time.Second, 10*time.Millisecond)
+	start := time.Now()
+	require.NoError(t, s.Close())
+	assert.Less(t, time.Since(start), maxAcceptDelay/2)
+}
+
+func TestNextAcceptDelay(t *testing.T) {
+	delay := time.Duration(0)
+	var delays []time.Duration
+	for range 10 {
+		delay = nextAcceptDelay(delay)
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, minAcceptDelay, delays[0])
+	assert.Equal(t, 10*time.Millisecond, delays[1])
+	assert.Equal(t, maxAcceptDelay, delays[9])
+}
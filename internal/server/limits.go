@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+const (
+	defaultMaxRequestLineSize = 8 << 10
+	defaultMaxHeaderSize      = 64 << 10
+	rejectWriteTimeout        = time.Second
+)
+
+// Limits bound the resources clients can hold. Zero values mean no limit,
+// except for the sizes which have defaults. Hijacked connections count
+// against the connection caps until they are closed.
+type Limits struct {
+	// MaxConns caps the open connections, new ones get a 503.
+	MaxConns int
+	// MaxConnsPerIP caps the open connections from one client IP, after a
+	// PROXY protocol header is applied. New ones get a 503.
+	MaxConnsPerIP int
+	// HeaderTimeout is how long a request line and headers may take to
+	// arrive, measured from when the server starts waiting for the request,
+	// so it also closes idle keep-alive connections. A late request gets a
+	// 408.
+	HeaderTimeout time.Duration
+	// MinBodyRate is the slowest a request body may arrive in bytes per
+	// second after a 5 second grace period, a slower one gets a 408.
+	MinBodyRate int
+	// MaxRequestLineSize defaults to 8 KiB, a longer line gets a 414.
+	MaxRequestLineSize int
+	// MaxHeaderSize defaults to 64 KiB, a larger header section gets a 431.
+	MaxHeaderSize int
+}
+
+// WithLimits protects the server from clients that open too many
+// connections or send huge or slow requests.
+func WithLimits(limits Limits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+func (l Limits) request() request.Limits {
+	limits := request.Limits{
+		MaxRequestLineSize: l.MaxRequestLineSize,
+		MaxHeaderSize:      l.MaxHeaderSize,
+		HeaderTimeout:      l.HeaderTimeout,
+		MinBodyRate:        l.MinBodyRate,
+	}
+	if limits.MaxRequestLineSize <= 0 {
+		limits.MaxRequestLineSize = defaultMaxRequestLineSize
+	}
+	if limits.MaxHeaderSize <= 0 {
+		limits.MaxHeaderSize = defaultMaxHeaderSize
+	}
+	return limits
+}
+
+// acquireConn counts a new connection, reporting false when MaxConns are
+// already open.
+func (s *Server) acquireConn() bool {
+	if s.limits.MaxConns > 0 && s.conns.Load() >= int64(s.limits.MaxConns) {
+		return false
+	}
+	s.conns.Add(1)
+	return true
+}
+
+func (s *Server) releaseConn() {
+	s.conns.Add(-1)
+}
+
+// acquireIP counts a new connection from ip, reporting false when
+// MaxConnsPerIP are already open.
+func (s *Server) acquireIP(ip string) bool {
+	s.ipMu.Lock()
+	defer s.ipMu.Unlock()
+	if s.connsPerIP[ip] >= s.limits.MaxConnsPerIP {
+		return false
+	}
+	if s.connsPerIP == nil {
+		s.connsPerIP = make(map[string]int)
+	}
+	s.connsPerIP[ip]++
+	return true
+}
+
+func (s *Server) releaseIP(ip string) {
+	s.ipMu.Lock()
+	defer s.ipMu.Unlock()
+	if s.connsPerIP[ip]--; s.connsPerIP[ip] <= 0 {
+		delete(s.connsPerIP, ip)
+	}
+}
+
+// slotConn releases a connection's limit slots when it is closed, so
+// hijacked connections keep counting against the limits.
+type slotConn struct {
+	net.Conn
+	release []func()
+	once    sync.Once
+}
+
+func (c *slotConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseSlots()
+	return err
+}
+
+// CloseWrite half-closes the connection if it supports that and closes it
+// otherwise.
+func (c *slotConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (c *slotConn) releaseSlots() {
+	c.once.Do(func() {
+		for _, release := range c.release {
+			release()
+		}
+	})
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// writeUnavailable answers a connection over the limits with a 503. TLS
+// connections are rejected before the handshake, so they are just closed.
+func writeUnavailable(conn net.Conn) {
+	if _, ok := conn.(*tls.Conn); ok {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+	writeError(conn, response.ServiceUnavailable, "too many connections")
+}
+
+// writeParseError answers a request that could not be read.
+func writeParseError(conn net.Conn, err *request.ParseError) {
+	switch err.Kind {
+	case request.KindTimeout:
+		writeError(conn, response.RequestTimeout, "request timeout")
+	case request.KindRequestLineTooLong:
+		writeError(conn, response.URITooLong, "request line too long")
+	case request.KindHeaderTooLarge:
+		writeError(conn, response.HeaderTooLarge, "request header fields too large")
+	case request.KindTransferEncoding:
+		writeError(conn, response.NotImplemented, "transfer encoding not supported")
+	default:
+		writeError(conn, response.BadRequest, "error parsing request")
+	}
+}
+
+func writeError(conn net.Conn, statusCode response.StatusCode, body string) {
+	w := response.NewWriter(conn)
+	w.WriteStatusLine(statusCode)
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Connection", "close")
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
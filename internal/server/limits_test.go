@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+// roundTrip sends raw on conn and returns the status code of the response.
+func roundTrip(t *testing.T, conn net.Conn, raw string) int {
+	t.Helper()
+	_, err := io.WriteString(conn, raw)
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServerMaxConns(t *testing.T) {
+	for name, limits := range map[string]Limits{
+		"total":  {MaxConns: 1},
+		"per IP": {MaxConnsPerIP: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := startServer(t, echoTargetHandler, WithLimits(limits))
+			first := dial(t, s)
+			assert.Equal(t, http.StatusOK, roundTrip(t, first, "GET /a HTTP/1.1\r\n\r\n"))
+
+			// Test: Connections over the limit get a 503
+			resp, err := http.ReadResponse(bufio.NewReader(dial(t, s)), nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+			// Test: Closing a connection frees its slot
+			first.Close()
+			assert.Eventually(t, func() bool {
+				conn := dial(t, s)
+				defer conn.Close()
+				return roundTrip(t, conn, "GET /b HTTP/1.1\r\n\r\n") == http.StatusOK
+			}, 2*time.Second, 20*time.Millisecond)
+		})
+	}
+}
+
+func TestServerMaxConnsHijacked(t *testing.T) {
+	// hijacks /hijack and keeps the connection open after returning
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget != "/hijack" {
+			echoTargetHandler(w, req)
+			return
+		}
+		conn, _, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}()
+	}
+
+	for name, limits := range map[string]Limits{
+		"total":  {MaxConns: 1},
+		"per IP": {MaxConnsPerIP: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := startServer(t, handler, WithLimits(limits))
+			hijacked := dial(t, s)
+			_, err := io.WriteString(hijacked, "GET /hijack HTTP/1.1\r\n\r\n")
+			require.NoError(t, err)
+
+			// Test: Hijacked connections keep their slot
+			assert.Eventually(t, func() bool {
+				resp, err := http.ReadResponse(bufio.NewReader(dial(t, s)), nil)
+				return err == nil && resp.StatusCode == http.StatusServiceUnavailable
+			}, 2*time.Second, 20*time.Millisecond)
+
+			// Test: Closing the hijacked connection frees it
+			hijacked.Close()
+			assert.Eventually(t, func() bool {
+				conn := dial(t, s)
+				defer conn.Close()
+				return roundTrip(t, conn, "GET /b HTTP/1.1\r\n\r\n") == http.StatusOK
+			}, 2*time.Second, 20*time.Millisecond)
+		})
+	}
+}
+
+func TestServerHeaderTimeout(t *testing.T) {
+	s := startServer(t, echoTargetHandler, WithLimits(Limits{HeaderTimeout: 100 * time.Millisecond}))
+
+	// Test: A slow head gets a 408
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: loc")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+
+	// Test: Idle connections are closed without a response
+	conn = dial(t, s)
+	assert.Equal(t, http.StatusOK, roundTrip(t, conn, "GET / HTTP/1.1\r\n\r\n"))
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestServerRequestSizeLimits(t *testing.T) {
+	s := startServer(t, echoTargetHandler, WithLimits(Limits{MaxRequestLineSize: 64, MaxHeaderSize: 128}))
+
+	// Test: Long request lines get a 414
+	conn := dial(t, s)
+	status := roundTrip(t, conn, "GET /"+strings.Repeat("a", 100)+" HTTP/1.1\r\n\r\n")
+	assert.Equal(t, http.StatusRequestURITooLong, status)
+
+	// Test: Large header sections get a 431, even before they end
+	conn = dial(t, s)
+	status = roundTrip(t, conn, "GET / HTTP/1.1\r\nCookie: "+strings.Repeat("a", 200))
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, status)
+
+	// Test: The default limits apply without WithLimits
+	s = startServer(t, echoTargetHandler)
+	conn = dial(t, s)
+	status = roundTrip(t, conn, "GET /"+strings.Repeat("a", 10<<10)+" HTTP/1.1\r\n\r\n")
+	assert.Equal(t, http.StatusRequestURITooLong, status)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// Observer is told about connection level events handlers don't see, e.g.
+// to export them as metrics. Its methods are called from many goroutines.
+type Observer interface {
+	ConnOpened()
+	// ConnClosed is called once per opened connection, including hijacked
+	// ones when their new owner closes them.
+	ConnClosed()
+	BytesRead(n int)
+	BytesWritten(n int)
+	// ParseError is called with the request.ParseError kind of each
+	// request that couldn't be parsed, or "proxy_header" for a rejected
+	// PROXY protocol header.
+	ParseError(kind string)
+}
+
+// observedListener reports accepted connections and the bytes they carry
+// on the wire, below any TLS layer.
+type observedListener struct {
+	net.Listener
+	observer Observer
+}
+
+func (l observedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.observer.ConnOpened()
+	return &observedConn{Conn: conn, observer: l.observer}, nil
+}
+
+type observedConn struct {
+	net.Conn
+	observer  Observer
+	closeOnce sync.Once
+}
+
+func (c *observedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.observer.BytesRead(n)
+	}
+	return n, err
+}
+
+func (c *observedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.observer.BytesWritten(n)
+	}
+	return n, err
+}
+
+func (c *observedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection does not support half-close")
+}
+
+func (c *observedConn) Close() error {
+	c.closeOnce.Do(c.observer.ConnClosed)
+	return c.Conn.Close()
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+type Option func(*Server)
+
+// WithMaxPipelineDepth caps how many pipelined requests are served back to
+// back on a connection before it is closed.
+func WithMaxPipelineDepth(depth int) Option {
+	return func(s *Server) {
+		s.maxPipelineDepth = depth
+	}
+}
+
+// WithObserver reports connection events to observer.
+func WithObserver(observer Observer) Option {
+	return func(s *Server) {
+		s.observer = observer
+	}
+}
+
+// WithRequestTimeout sets a deadline on every request's context. Handlers
+// have to watch the context for it to take effect.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = timeout
+	}
+}
+
+// WithErrorLog sets the logger for errors the server can't return, like
+// failing to accept connections. The standard logger is used by default.
+func WithErrorLog(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.errorLog = logger
+	}
+}
+
+// WithAcceptErrorHandler calls handler with every error from accepting a
+// connection, before the server retries or stops accepting.
+func WithAcceptErrorHandler(handler func(err error)) Option {
+	return func(s *Server) {
+		s.onAcceptError = handler
+	}
+}
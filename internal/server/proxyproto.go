@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/proxyproto"
+)
+
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+type ProxyProtocolConfig struct {
+	// TrustedSources are the networks of the load balancers, like
+	// netip.MustParsePrefix("10.0.0.0/8"). Connections from them must start
+	// with a PROXY protocol header, connections from anywhere else are
+	// served as they are.
+	TrustedSources []netip.Prefix
+	// HeaderTimeout bounds how long reading the header may take, 5 seconds
+	// by default.
+	HeaderTimeout time.Duration
+}
+
+// WithProxyProtocol reads HAProxy PROXY protocol v1 and v2 headers from
+// trusted load balancers and reports the client they carry as the remote
+// address of the connection.
+func WithProxyProtocol(config ProxyProtocolConfig) Option {
+	return func(s *Server) {
+		s.proxyProtocol = &config
+	}
+}
+
+// proxyListener wraps connections from trusted sources so their header is
+// read before anything else.
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+func newProxyListener(l net.Listener, config ProxyProtocolConfig) (*proxyListener, error) {
+	pl := &proxyListener{Listener: l, timeout: config.HeaderTimeout}
+	if pl.timeout <= 0 {
+		pl.timeout = defaultProxyHeaderTimeout
+	}
+	for _, prefix := range config.TrustedSources {
+		if !prefix.IsValid() {
+			return nil, fmt.Errorf("invalid trusted source %v", prefix)
+		}
+		pl.trusted = append(pl.trusted, prefix.Masked())
+	}
+	return pl, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reports the addresses from the PROXY header once readHeader
+// has succeeded.
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+	header  *proxyproto.Header
+}
+
+// readHeader is called by handle before the connection is used, so header
+// isn't accessed concurrently.
+func (c *proxyConn) readHeader() error {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	header, err := proxyproto.Read(c.Conn)
+	if err != nil {
+		return err
+	}
+	c.Conn.SetReadDeadline(time.Time{})
+	c.header = header
+	return nil
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("connection does not support half-close")
+}
+
+// readProxyHeader reads the PROXY header if conn came from a trusted source,
+// looking through the TLS layer for it.
+func readProxyHeader(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.readHeader()
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+func remoteAddrHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RemoteAddr + " " + req.LocalAddr)
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	s := startServer(t, remoteAddrHandler, WithProxyProtocol(ProxyProtocolConfig{
+		TrustedSources: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")},
+	}))
+
+	// Test: Addresses come from the header
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"+
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies := readBodies(t, bufio.NewReader(conn), 2)
+	assert.Equal(t, []string{"192.0.2.1:56324 192.0.2.2:443", "192.0.2.1:56324 192.0.2.2:443"}, bodies)
+
+	// Test: Malformed preamble closes the connection unanswered
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "PROXY TCP4 nonsense\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: Trusted sources must send a header
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	rest, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestServerProxyProtocolUntrusted(t *testing.T) {
+	s := startServer(t, remoteAddrHandler, WithProxyProtocol(ProxyProtocolConfig{
+		TrustedSources: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}))
+
+	// Test: Headers from untrusted sources aren't interpreted
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Test: Plain requests from untrusted sources are served
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies := readBodies(t, bufio.NewReader(conn), 1)
+	assert.Equal(t, conn.LocalAddr().String()+" "+conn.RemoteAddr().String(), bodies[0])
+
+	// Test: Invalid prefix
+	_, err = Serve(0, remoteAddrHandler, WithProxyProtocol(ProxyProtocolConfig{TrustedSources: []netip.Prefix{{}}}))
+	assert.Error(t, err)
+}
+
+func TestServerProxyProtocolTLS(t *testing.T) {
+	cert := writeSelfSigned(t, t.TempDir(), "default", "default", "localhost")
+	s, err := ServeTLS(0, remoteAddrHandler, TLSConfig{Certificates: []CertificateFiles{cert}},
+		WithProxyProtocol(ProxyProtocolConfig{TrustedSources: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}}))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	// Test: Header precedes the TLS handshake
+	raw := dial(t, s)
+	_, err = io.WriteString(raw, "PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n")
+	require.NoError(t, err)
+	conn := tls.Client(raw, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies := readBodies(t, bufio.NewReader(conn), 1)
+	assert.Equal(t, "[2001:db8::1]:1234 [2001:db8::2]:443", bodies[0])
+}
@@ -1,12 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
 	"github.com/dmytrochumakov/httpfromtcp/internal/request"
 	"github.com/dmytrochumakov/httpfromtcp/internal/response"
 )
@@ -17,27 +23,105 @@ const (
 	initialized ServerState = iota
 )
 
+const (
+	defaultMaxPipelineDepth = 16
+	lingerTimeout           = 500 * time.Millisecond
+	handshakeTimeout        = 10 * time.Second
+)
+
 type Server struct {
 	state    ServerState
 	listener net.Listener
 	wg       sync.WaitGroup
 	closed   atomic.Bool
 	handler  Handler
+	connIDs  atomic.Uint64
+
+	// ctx is the parent of every request context, Close cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	maxPipelineDepth int
+	tlsConfig        *TLSConfig
+	certs            *certStore
+	observer         Observer
+	requestTimeout   time.Duration
+	proxyProtocol    *ProxyProtocolConfig
+	limits           Limits
+	errorLog         *log.Logger
+	onAcceptError    func(err error)
+
+	conns      atomic.Int64
+	ipMu       sync.Mutex
+	connsPerIP map[string]int
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
-func Serve(port int, handler Handler) (*Server, error) {
-	addr := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", addr)
+type connContextKey struct{}
+
+// ConnContext returns the context of the connection req arrived on. Unlike
+// the request's context it has no deadline and isn't cancelled when the
+// handler returns or the client goes quiet, only when the server shuts down
+// or stops serving the connection, which makes it the one to tie hijacked
+// connections to. Requests not served by a Server get their own context.
+func ConnContext(req *request.Request) context.Context {
+	if ctx, ok := req.Context().Value(connContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return req.Context()
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return ServeListener(listener, handler, opts...)
+}
 
+// ServeListener serves the connections accepted from listener, which is
+// closed by Close or when the server fails to start.
+func ServeListener(listener net.Listener, handler Handler, opts ...Option) (*Server, error) {
 	server := Server{
 		state:    initialized,
 		listener: listener,
 		handler:  handler,
+
+		maxPipelineDepth: defaultMaxPipelineDepth,
+	}
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(&server)
+	}
+	if server.observer != nil {
+		server.listener = observedListener{Listener: listener, observer: server.observer}
+	}
+	if server.proxyProtocol != nil {
+		pl, err := newProxyListener(server.listener, *server.proxyProtocol)
+		if err != nil {
+			listener.Close()
+			server.cancel()
+			return nil, err
+		}
+		server.listener = pl
+	}
+	if server.tlsConfig != nil {
+		certs, err := newCertStore(*server.tlsConfig)
+		if err != nil {
+			listener.Close()
+			server.cancel()
+			return nil, err
+		}
+		certs.logf = server.logf
+		server.certs = certs
+		server.listener = tls.NewListener(server.listener, certs.tlsConfig(*server.tlsConfig))
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			certs.watch()
+		}()
 	}
 	server.closed.Store(false)
 
@@ -47,8 +131,16 @@ func Serve(port int, handler Handler) (*Server, error) {
 	return &server, nil
 }
 
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	s.cancel()
+	if s.certs != nil {
+		s.certs.close()
+	}
 	err := s.listener.Close()
 	if err != nil {
 		return err
@@ -57,32 +149,220 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// listen accepts connections until the server is closed. Temporary accept
+// errors, like running out of file descriptors, are retried with an
+// exponential backoff, any other error stops the loop.
 func (s *Server) listen() {
 	defer s.wg.Done()
+	var delay time.Duration
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
 			if s.closed.Load() {
 				return
 			}
+			if s.onAcceptError != nil {
+				s.onAcceptError(err)
+			}
+			if !isTemporary(err) {
+				s.logf("server: accept: %v; no longer accepting connections", err)
+				return
+			}
+			delay = nextAcceptDelay(delay)
+			s.logf("server: accept: %v; retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-s.ctx.Done():
+				return
+			}
 			continue
 		}
+		delay = 0
 
+		if !s.acquireConn() {
+			// don't let clients that never read stall the accept loop
+			go func() {
+				writeUnavailable(conn)
+				conn.Close()
+			}()
+			continue
+		}
 		go s.handle(conn)
 	}
 }
 
+// handle serves requests on conn one at a time until either side asks to
+// close it. Requests the client pipelined are parsed from the bytes the
+// reader kept after the previous one, so responses always go out in request
+// order.
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		w.WriteStatusLine(response.BadRequest)
-		body := "error parsing request"
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody([]byte(body))
+	// the connection's limit slots, a hijacked connection holds them until
+	// it is closed
+	slots := &slotConn{Conn: conn, release: []func(){s.releaseConn}}
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			closeConn(conn)
+			slots.releaseSlots()
+		}
+	}()
+
+	// the PROXY header comes before the TLS handshake
+	if err := readProxyHeader(conn); err != nil {
+		if s.observer != nil {
+			s.observer.ParseError("proxy_header")
+		}
 		return
 	}
 
-	s.handler(w, req)
+	if s.limits.MaxConnsPerIP > 0 {
+		ip := remoteIP(conn)
+		if !s.acquireIP(ip) {
+			writeUnavailable(conn)
+			return
+		}
+		slots.release = append(slots.release, func() { s.releaseIP(ip) })
+	}
+
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+	connID := s.connIDs.Add(1)
+
+	connCtx, cancelConn := context.WithCancel(s.ctx)
+	defer cancelConn()
+	connCtx = context.WithValue(connCtx, connContextKey{}, connCtx)
+
+	reader := request.NewReader(conn)
+	reader.SetLimits(s.limits.request())
+	defer reader.Release()
+
+	depth := 0
+	// readAhead is set when the buffered bytes were read by watchClose,
+	// which means they arrived after the previous request was parsed and
+	// don't count as pipelined
+	readAhead := false
+	for sequence := 1; ; sequence++ {
+		pipelined := reader.Buffered() > 0 && !readAhead
+		req, err := reader.ReadRequest()
+		if err != nil {
+			var parseErr *request.ParseError
+			if !errors.As(err, &parseErr) {
+				// the client went away or an idle connection timed out
+				return
+			}
+			if s.observer != nil {
+				s.observer.ParseError(parseErr.Kind)
+			}
+			writeParseError(conn, parseErr)
+			return
+		}
+
+		if pipelined {
+			depth++
+		} else {
+			depth = 0
+		}
+		if depth > s.maxPipelineDepth {
+			// leave the rest unanswered, clients retry them on a new connection
+			return
+		}
+
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.LocalAddr = conn.LocalAddr().String()
+		req.TLS = tlsState
+		req.ConnID = connID
+		req.Sequence = sequence
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if s.requestTimeout > 0 {
+			ctx, cancel = context.WithTimeout(connCtx, s.requestTimeout)
+		} else {
+			ctx, cancel = context.WithCancel(connCtx)
+		}
+		req = req.WithContext(ctx)
+
+		// without pipelined bytes to parse, read ahead to notice the client
+		// going away while the handler runs
+		stopWatching := func() {}
+		readAhead = reader.Buffered() == 0
+		if readAhead {
+			stopWatching = watchClose(conn, reader, cancel)
+		}
+
+		w := response.NewConnWriter(slots, func() []byte {
+			stopWatching()
+			return reader.Detach()
+		})
+		s.handler(w, req)
+		stopWatching()
+		cancel()
+		if w.Hijacked() {
+			hijacked = true
+			return
+		}
+		if err := w.Finish(); err != nil {
+			return
+		}
+		if w.ShouldClose() || !keepAlive(req) {
+			return
+		}
+	}
+}
+
+// watchClose reads ahead on conn in the background and calls cancel if the
+// read fails, which means the client closed or reset the connection. A
+// client that only half-closes after sending its request counts as gone.
+// The returned func stops the read and waits for it, it may be called more
+// than once.
+func watchClose(conn net.Conn, reader *request.Reader, cancel context.CancelFunc) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := reader.ReadAhead()
+		var netErr net.Error
+		if err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			cancel()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// a deadline in the past unblocks the pending read
+			conn.SetReadDeadline(time.Unix(1, 0))
+			<-done
+			conn.SetReadDeadline(time.Time{})
+		})
+	}
+}
+
+func keepAlive(req *request.Request) bool {
+	if headers.HasToken(req.Headers, "Connection", "close") {
+		return false
+	}
+	if headers.HasToken(req.Headers, "Connection", "keep-alive") {
+		return true
+	}
+	return req.RequestLine.HttpVersion != "1.0"
+}
+
+// closeConn half-closes conn and drains what the client already sent, so
+// unread pipelined requests don't make the kernel reset the connection
+// before the client has read our last response.
+func closeConn(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+		io.Copy(io.Discard, conn)
+	}
+	conn.Close()
 }
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+func echoTargetHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func startServer(t *testing.T, handler Handler, opts ...Option) *Server {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// readBodies reads count responses from r and returns their bodies.
+func readBodies(t *testing.T, r *bufio.Reader, count int) []string {
+	t.Helper()
+	bodies := make([]string, 0, count)
+	for range count {
+		resp, err := http.ReadResponse(r, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestServerPipelining(t *testing.T) {
+	// Test: Responses come back in request order
+	s := startServer(t, echoTargetHandler)
+	conn := dial(t, s)
+	_, err := io.WriteString(conn,
+		"GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"POST /two HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc"+
+			"GET /three HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies := readBodies(t, bufio.NewReader(conn), 3)
+	assert.Equal(t, []string{"/one", "/two", "/three"}, bodies)
+
+	// Test: Connection: close ends the connection after its response
+	conn = dial(t, s)
+	_, err = io.WriteString(conn,
+		"GET /last HTTP/1.1\r\nConnection: close\r\n\r\n"+
+			"GET /ignored HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "/last")
+	assert.NotContains(t, string(data), "/ignored")
+}
+
+func TestServerTransferEncoding(t *testing.T) {
+	s := startServer(t, echoTargetHandler)
+
+	// Test: A chunked body is never served as the next request
+	conn := dial(t, s)
+	_, err := io.WriteString(conn,
+		"POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"1a\r\nGET /smuggled HTTP/1.1\r\n\r\n\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "HTTP/1.1 501 Not Implemented"))
+	assert.NotContains(t, string(data), "/smuggled")
+	assert.NotContains(t, string(data), "/upload")
+
+	// Test: Content-Length doesn't frame a body that also has a
+	// Transfer-Encoding
+	conn = dial(t, s)
+	_, err = io.WriteString(conn,
+		"POST /upload HTTP/1.1\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			"0\r\n\r\nGET /smuggled HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	data, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "HTTP/1.1 400 Bad Request"))
+	assert.NotContains(t, string(data), "/smuggled")
+}
+
+func TestServerPipelineDepth(t *testing.T) {
+	// Test: Requests past the depth cap are left unanswered
+	s := startServer(t, echoTargetHandler, WithMaxPipelineDepth(1))
+	conn := dial(t, s)
+	_, err := io.WriteString(conn,
+		"GET /a HTTP/1.1\r\n\r\n"+
+			"GET /b HTTP/1.1\r\n\r\n"+
+			"GET /c HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "/a")
+	assert.Contains(t, string(data), "/b")
+	assert.NotContains(t, string(data), "/c")
+}
+
+func TestServerPipelineDepthReadAhead(t *testing.T) {
+	// Test: Requests read ahead while a handler runs are not pipelined
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		time.Sleep(50 * time.Millisecond)
+		echoTargetHandler(w, req)
+	}, WithMaxPipelineDepth(0))
+	conn := dial(t, s)
+	for _, target := range []string{"/a", "/b", "/c"} {
+		_, err := io.WriteString(conn, "GET "+target+" HTTP/1.1\r\n\r\n")
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+	}
+	bodies := readBodies(t, bufio.NewReader(conn), 3)
+	assert.Equal(t, []string{"/a", "/b", "/c"}, bodies)
+}
+
+func TestServerHijack(t *testing.T) {
+	// Test: Hijacked connection outlives the handler and keeps buffered bytes
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			extra := make([]byte, len("EXTRA"))
+			_, err := io.ReadFull(io.MultiReader(bytes.NewReader(buffered), conn), extra)
+			if err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			io.WriteString(conn, "raw:"+string(extra))
+		}()
+	})
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET /hijack HTTP/1.1\r\n\r\nEXTRA")
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "raw:EXTRA", string(data))
+
+	// Test: Second hijack fails
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		conn, _, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := w.Hijack(); err != nil {
+			io.WriteString(conn, err.Error())
+		}
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET /hijack HTTP/1.1\r\n\r\n")
+	require.NoError(t, err)
+	data, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "connection already hijacked", string(data))
+}
+
+func TestServerRequestContext(t *testing.T) {
+	// Test: Client disconnect cancels the context
+	cancelled := make(chan error, 1)
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled after disconnect")
+	}
+
+	// Test: Shutdown cancels the context
+	started := make(chan struct{})
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+	s.Close()
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled on shutdown")
+	}
+
+	// Test: Request timeout sets a deadline
+	s = startServer(t, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		body := []byte(req.Context().Err().Error())
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithRequestTimeout(50*time.Millisecond))
+	conn = dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: Keep-alive still works while connections are watched
+	for range 2 {
+		_, err = io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, []string{context.DeadlineExceeded.Error()}, readBodies(t, r, 1))
+	}
+}
+
+func TestRequestWithContext(t *testing.T) {
+	type key struct{}
+
+	// Test: Middleware attaches values
+	withUser := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req.WithContext(context.WithValue(req.Context(), key{}, "alice")))
+		}
+	}
+	s := startServer(t, withUser(func(w *response.Writer, req *request.Request) {
+		body := []byte(req.Context().Value(key{}).(string))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}))
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, readBodies(t, bufio.NewReader(conn), 1))
+}
+
+func TestRequestConnInfo(t *testing.T) {
+	s := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := fmt.Appendf(nil, "%s %s %d %d %t", req.RemoteAddr, req.LocalAddr, req.ConnID, req.Sequence, req.TLS != nil)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	// Test: Addresses, connection ID and sequence
+	first := dial(t, s)
+	_, err := io.WriteString(first, "GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\nGET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies := readBodies(t, bufio.NewReader(first), 2)
+	prefix := first.LocalAddr().String() + " " + first.RemoteAddr().String()
+	assert.Equal(t, prefix+" 1 1 false", bodies[0])
+	assert.Equal(t, prefix+" 1 2 false", bodies[1])
+
+	// Test: Each connection gets a new ID
+	second := dial(t, s)
+	_, err = io.WriteString(second, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies = readBodies(t, bufio.NewReader(second), 1)
+	assert.Equal(t, second.LocalAddr().String()+" "+second.RemoteAddr().String()+" 2 1 false", bodies[0])
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultCertReloadInterval = 10 * time.Second
+
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+type TLSConfig struct {
+	// Certificates are picked by SNI; the first one is served to clients
+	// that send no server name or one none of them covers.
+	Certificates []CertificateFiles
+	// MinVersion defaults to TLS 1.2.
+	MinVersion uint16
+	// CipherSuites only applies to TLS 1.2 and below, nil keeps the
+	// crypto/tls defaults.
+	CipherSuites []uint16
+	// ReloadInterval is how often the certificate files are checked for
+	// changes, it defaults to 10 seconds.
+	ReloadInterval time.Duration
+}
+
+func WithTLS(config TLSConfig) Option {
+	return func(s *Server) {
+		s.tlsConfig = &config
+	}
+}
+
+func ServeTLS(port int, handler Handler, config TLSConfig, opts ...Option) (*Server, error) {
+	return Serve(port, handler, append(opts, WithTLS(config))...)
+}
+
+type certEntry struct {
+	files   CertificateFiles
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// certStore holds the loaded certificates and swaps them when their files
+// change on disk, so rotating a certificate doesn't need a restart.
+type certStore struct {
+	mu       sync.RWMutex
+	entries  []certEntry
+	interval time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+	logf     func(format string, args ...any)
+}
+
+func newCertStore(config TLSConfig) (*certStore, error) {
+	if len(config.Certificates) == 0 {
+		return nil, errors.New("tls: no certificates configured")
+	}
+	interval := config.ReloadInterval
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	store := &certStore{
+		entries:  make([]certEntry, 0, len(config.Certificates)),
+		interval: interval,
+		done:     make(chan struct{}),
+		logf:     log.Printf,
+	}
+	for _, files := range config.Certificates {
+		entry, err := loadCertificate(files)
+		if err != nil {
+			return nil, err
+		}
+		store.entries = append(store.entries, entry)
+	}
+	return store, nil
+}
+
+func (c *certStore) tlsConfig(config TLSConfig) *tls.Config {
+	minVersion := config.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   config.CipherSuites,
+		GetCertificate: c.getCertificate,
+	}
+}
+
+func (c *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if hello.ServerName != "" {
+		for _, entry := range c.entries {
+			if hello.SupportsCertificate(entry.cert) == nil {
+				return entry.cert, nil
+			}
+		}
+	}
+	return c.entries[0].cert, nil
+}
+
+func (c *certStore) watch() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.reload()
+		}
+	}
+}
+
+func (c *certStore) close() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// reload re-reads certificates whose files changed. A pair that fails to
+// load keeps the previous certificate, since the cert and key are often
+// written one after the other.
+func (c *certStore) reload() {
+	c.mu.RLock()
+	entries := make([]certEntry, len(c.entries))
+	copy(entries, c.entries)
+	c.mu.RUnlock()
+
+	for i, entry := range entries {
+		modTime, err := latestModTime(entry.files)
+		if err != nil {
+			c.logf("tls: checking %s: %v", entry.files.CertFile, err)
+			continue
+		}
+		// files swapped in with an older timestamp, as when restored from
+		// a backup or synced with their times kept, count as changed too
+		if modTime.Equal(entry.modTime) {
+			continue
+		}
+		updated, err := loadCertificate(entry.files)
+		if err != nil {
+			c.logf("tls: reloading %s: %v", entry.files.CertFile, err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.entries[i] = updated
+		c.mu.Unlock()
+	}
+}
+
+func loadCertificate(files CertificateFiles) (certEntry, error) {
+	modTime, err := latestModTime(files)
+	if err != nil {
+		return certEntry{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return certEntry{}, fmt.Errorf("tls: loading %s: %w", files.CertFile, err)
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return certEntry{}, fmt.Errorf("tls: parsing %s: %w", files.CertFile, err)
+		}
+	}
+	return certEntry{
+		files:   files,
+		cert:    &cert,
+		modTime: modTime,
+	}, nil
+}
+
+func latestModTime(files CertificateFiles) (time.Time, error) {
+	certInfo, err := os.Stat(files.CertFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(files.KeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+// writeSelfSigned writes a fresh self-signed certificate for names into dir
+// and returns its file paths.
+func writeSelfSigned(t *testing.T, dir, prefix, commonName string, names ...string) CertificateFiles {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	files := CertificateFiles{
+		CertFile: filepath.Join(dir, prefix+".crt"),
+		KeyFile:  filepath.Join(dir, prefix+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(files.CertFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(files.KeyFile, keyPEM, 0o600))
+	return files
+}
+
+// peerCommonName connects with serverName and returns the common name of
+// the certificate the server presented, or "" if the handshake failed. It
+// doesn't stop the test, so it can be polled from assert.Eventually.
+func peerCommonName(t *testing.T, s *Server, serverName string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert := writeSelfSigned(t, dir, "default", "default", "localhost")
+	apiCert := writeSelfSigned(t, dir, "api", "api", "api.example.com", "*.api.example.com")
+
+	tlsStateHandler := func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.RequestTarget)
+		if req.TLS != nil {
+			body = fmt.Appendf(body, " %s", req.TLS.ServerName)
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	s, err := ServeTLS(0, tlsStateHandler, TLSConfig{
+		Certificates:   []CertificateFiles{defaultCert, apiCert},
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	// Test: Request over TLS sees the connection state
+	conn, err := tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "GET /secure HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	bodies := readBodies(t, bufio.NewReader(conn), 1)
+	assert.Equal(t, []string{"/secure localhost"}, bodies)
+	conn.Close()
+
+	// Test: Certificate selected by SNI
+	assert.Equal(t, "api", peerCommonName(t, s, "api.example.com"))
+	assert.Equal(t, "api", peerCommonName(t, s, "v1.api.example.com"))
+	assert.Equal(t, "default", peerCommonName(t, s, "localhost"))
+	assert.Equal(t, "default", peerCommonName(t, s, "unknown.test"))
+
+	// Test: Default minimum version rejects TLS 1.1
+	_, err = tls.Dial("tcp", s.listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS11,
+	})
+	assert.Error(t, err)
+
+	// Test: Certificate reloaded after the files change
+	future := time.Now().Add(time.Minute)
+	writeSelfSigned(t, dir, "api", "api-rotated", "api.example.com")
+	require.NoError(t, os.Chtimes(apiCert.CertFile, future, future))
+	assert.Eventually(t, func() bool {
+		return peerCommonName(t, s, "api.example.com") == "api-rotated"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// Test: Files replaced by older ones are reloaded too
+	past := time.Now().Add(-time.Hour)
+	writeSelfSigned(t, dir, "api", "api-restored", "api.example.com")
+	require.NoError(t, os.Chtimes(apiCert.CertFile, past, past))
+	require.NoError(t, os.Chtimes(apiCert.KeyFile, past, past))
+	assert.Eventually(t, func() bool {
+		return peerCommonName(t, s, "api.example.com") == "api-restored"
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestServeTLSErrors(t *testing.T) {
+	// Test: No certificates
+	_, err := ServeTLS(0, echoTargetHandler, TLSConfig{})
+	assert.Error(t, err)
+
+	// Test: Missing files
+	_, err = ServeTLS(0, echoTargetHandler, TLSConfig{
+		Certificates: []CertificateFiles{{CertFile: "missing.crt", KeyFile: "missing.key"}},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidValue = errors.New("sessions: invalid cookie value")
+
+// Codec protects cookie values either with an HMAC-SHA256 signature or with
+// AES-GCM encryption. The first key is used for new values, the rest are
+// only accepted when decoding, so keys can be rotated without logging
+// everyone out.
+type Codec struct {
+	keys    [][]byte
+	aeads   []cipher.AEAD
+	encrypt bool
+}
+
+func NewSigningCodec(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("sessions: no signing keys")
+	}
+	for _, key := range keys {
+		if len(key) < 32 {
+			return nil, errors.New("sessions: signing keys must be at least 32 bytes")
+		}
+	}
+	return &Codec{keys: keys}, nil
+}
+
+// NewEncryptingCodec takes AES keys of 16, 24 or 32 bytes.
+func NewEncryptingCodec(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("sessions: no encryption keys")
+	}
+	c := &Codec{keys: keys, encrypt: true}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("sessions: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("sessions: %w", err)
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Encode protects value for the cookie called name. The name is
+// authenticated too, so a value can't be moved to another cookie.
+func (c *Codec) Encode(name string, value []byte) (string, error) {
+	if c.encrypt {
+		aead := c.aeads[0]
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		sealed := aead.Seal(nonce, nonce, value, []byte(name))
+		return base64.RawURLEncoding.EncodeToString(sealed), nil
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(value)
+	return payload + "." + c.sign(c.keys[0], name, payload), nil
+}
+
+func (c *Codec) Decode(name, encoded string) ([]byte, error) {
+	value, _, err := c.decode(name, encoded)
+	return value, err
+}
+
+// decode also returns whether a key other than the current one was needed,
+// in which case the value should be encoded again.
+func (c *Codec) decode(name, encoded string) ([]byte, bool, error) {
+	if c.encrypt {
+		sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, false, ErrInvalidValue
+		}
+		for i, aead := range c.aeads {
+			if len(sealed) < aead.NonceSize() {
+				return nil, false, ErrInvalidValue
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			value, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+			if err == nil {
+				return value, i > 0, nil
+			}
+		}
+		return nil, false, ErrInvalidValue
+	}
+
+	payload, signature, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return nil, false, ErrInvalidValue
+	}
+	for i, key := range c.keys {
+		if hmac.Equal([]byte(signature), []byte(c.sign(key, name, payload))) {
+			value, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return nil, false, ErrInvalidValue
+			}
+			return value, i > 0, nil
+		}
+	}
+	return nil, false, ErrInvalidValue
+}
+
+func (c *Codec) sign(key []byte, name, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,297 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/cookie"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+const (
+	defaultCookieName = "session"
+	defaultTTL        = 24 * time.Hour
+	idBytes           = 32
+	// maxCookieSize is the smallest name=value size browsers must accept.
+	maxCookieSize = 4096
+)
+
+type Options struct {
+	// CookieName defaults to "session".
+	CookieName string
+	// TTL is how long a session lives after its last save, defaults to 24 hours.
+	TTL      time.Duration
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite cookie.SameSite
+}
+
+// Manager loads and saves sessions. With a Store only a signed session ID
+// goes into the cookie; without one the whole session is kept in the
+// cookie, signed or encrypted by the Codec.
+type Manager struct {
+	codec *Codec
+	store Store
+	opts  Options
+}
+
+func NewManager(codec *Codec, store Store, opts Options) *Manager {
+	if opts.CookieName == "" {
+		opts.CookieName = defaultCookieName
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultTTL
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	return &Manager{codec: codec, store: store, opts: opts}
+}
+
+type Session struct {
+	ID string
+
+	// oldID is the ID replaced by RenewID, deleted from the store on Save.
+	oldID     string
+	data      Data
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// contextKey is per Manager, so several managers with different cookies
+// can share a request.
+type contextKey struct{ m *Manager }
+
+// Middleware loads the session before calling next and keeps it in the
+// request context, so every Get during the request returns the same
+// Session. The handler still has to call Save before writing headers.
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		req, _, err := m.Load(req)
+		if err != nil {
+			body := "session unavailable\n"
+			w.WriteStatusLine(response.InternalServerError)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody([]byte(body))
+			return
+		}
+		next(w, req)
+	}
+}
+
+// Load is like Get but also returns a copy of req carrying the session in
+// its context, for later Get calls on that request.
+func (m *Manager) Load(req *request.Request) (*request.Request, *Session, error) {
+	if s, ok := req.Context().Value(contextKey{m}).(*Session); ok {
+		return req, s, nil
+	}
+	s, err := m.Get(req)
+	if err != nil {
+		return req, nil, err
+	}
+	return req.WithContext(context.WithValue(req.Context(), contextKey{m}, s)), s, nil
+}
+
+// Get returns the session for req, or a new empty one if the request has
+// none or it is invalid or expired. Once the request went through
+// Middleware or Load the cached session is returned instead.
+func (m *Manager) Get(req *request.Request) (*Session, error) {
+	if s, ok := req.Context().Value(contextKey{m}).(*Session); ok {
+		return s, nil
+	}
+	c, ok := cookie.Get(req, m.opts.CookieName)
+	if !ok {
+		return m.newSession()
+	}
+	value, rotated, err := m.codec.decode(m.opts.CookieName, c.Value)
+	if err != nil {
+		return m.newSession()
+	}
+
+	s := &Session{modified: rotated}
+	if m.store == nil {
+		if err := json.Unmarshal(value, &s.data); err != nil || s.data.expired() {
+			return m.newSession()
+		}
+	} else {
+		s.ID = string(value)
+		data, found, err := m.store.Get(s.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return m.newSession()
+		}
+		s.data = data
+	}
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]string)
+	}
+	return s, nil
+}
+
+// Save persists s and adds its Set-Cookie header, so it has to be called
+// before the headers are written.
+func (m *Manager) Save(w *response.Writer, s *Session) error {
+	if s.destroyed {
+		if m.store != nil {
+			for _, id := range []string{s.ID, s.oldID} {
+				if id == "" {
+					continue
+				}
+				if err := m.store.Delete(id); err != nil {
+					return err
+				}
+			}
+		}
+		return cookie.Set(w, m.cookie("", -1))
+	}
+	if !s.modified {
+		return nil
+	}
+
+	s.data.Expires = time.Now().Add(m.opts.TTL)
+	value := []byte(s.ID)
+	if m.store == nil {
+		raw, err := json.Marshal(s.data)
+		if err != nil {
+			return err
+		}
+		value = raw
+	}
+
+	encoded, err := m.codec.Encode(m.opts.CookieName, value)
+	if err != nil {
+		return err
+	}
+	if len(m.opts.CookieName)+1+len(encoded) > maxCookieSize {
+		return errors.New("sessions: session too large for a cookie")
+	}
+	if m.store != nil {
+		if err := m.store.Set(s.ID, s.data); err != nil {
+			return err
+		}
+		if s.oldID != "" {
+			if err := m.store.Delete(s.oldID); err != nil {
+				return err
+			}
+			s.oldID = ""
+		}
+	}
+	return cookie.Set(w, m.cookie(encoded, int(m.opts.TTL.Seconds())))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *cookie.Cookie {
+	return &cookie.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	}
+}
+
+func (m *Manager) newSession() (*Session, error) {
+	s := &Session{
+		data:  Data{Values: make(map[string]string)},
+		isNew: true,
+	}
+	if m.store != nil {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		s.ID = id
+	}
+	return s, nil
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	value, ok := s.data.Values[key]
+	return value, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.data.Values, key)
+	s.modified = true
+}
+
+// AddFlash queues a message that is returned once by Flashes, usually on
+// the next request.
+func (s *Session) AddFlash(message string) {
+	s.data.Flashes = append(s.data.Flashes, message)
+	s.modified = true
+}
+
+// Flashes returns and clears the queued flash messages.
+func (s *Session) Flashes() []string {
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// RenewID gives the session a new ID while keeping its values, and the old
+// one is removed from the store on the next Save. Call it when the user
+// logs in or their privileges change, so an ID planted by an attacker
+// before that is worthless. Cookie-only sessions have no ID and are just
+// re-encoded.
+func (s *Session) RenewID() error {
+	s.modified = true
+	if s.ID == "" {
+		return nil
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.ID
+	}
+	s.ID = id
+	return nil
+}
+
+// Destroy removes the session from the store and expires the cookie on the
+// next Save.
+func (s *Session) Destroy() {
+	s.destroyed = true
+}
+
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func newID() (string, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	if len(id) != idBytes*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
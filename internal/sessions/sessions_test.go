@@ -0,0 +1,305 @@
+package sessions
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func requestWithCookie(cookieHeader string) *request.Request {
+	h := headers.NewHeaders()
+	if cookieHeader != "" {
+		h.Set("Cookie", cookieHeader)
+	}
+	return &request.Request{Headers: h}
+}
+
+// save runs Save and returns the name=value part of the Set-Cookie line,
+// or "" when no cookie was set.
+func save(t *testing.T, m *Manager, s *Session) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, m.Save(w, s))
+	require.NoError(t, w.WriteStatusLine(response.OK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if value, ok := strings.CutPrefix(line, "set-cookie: "); ok {
+			pair, _, _ := strings.Cut(value, ";")
+			return pair
+		}
+	}
+	return ""
+}
+
+func TestCodec(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		var codec *Codec
+		var err error
+		if encrypt {
+			codec, err = NewEncryptingCodec(key1)
+		} else {
+			codec, err = NewSigningCodec(key1)
+		}
+		require.NoError(t, err)
+
+		// Test: Round trip
+		encoded, err := codec.Encode("session", []byte("payload"))
+		require.NoError(t, err)
+		assert.NotContains(t, encoded, "payload")
+		value, err := codec.Decode("session", encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(value))
+
+		// Test: Tampered value and wrong cookie name
+		_, err = codec.Decode("session", encoded[:len(encoded)-2]+"AA")
+		assert.ErrorIs(t, err, ErrInvalidValue)
+		_, err = codec.Decode("other", encoded)
+		assert.ErrorIs(t, err, ErrInvalidValue)
+
+		// Test: Key rotation
+		var rotated *Codec
+		if encrypt {
+			rotated, err = NewEncryptingCodec(key2, key1)
+		} else {
+			rotated, err = NewSigningCodec(key2, key1)
+		}
+		require.NoError(t, err)
+		value, needsRotation, err := rotated.decode("session", encoded)
+		require.NoError(t, err)
+		assert.True(t, needsRotation)
+		assert.Equal(t, "payload", string(value))
+	}
+
+	_, err := NewSigningCodec([]byte("short"))
+	assert.Error(t, err)
+	_, err = NewEncryptingCodec([]byte("not an aes key"))
+	assert.Error(t, err)
+}
+
+func TestManagerWithStore(t *testing.T) {
+	codec, err := NewSigningCodec(key1)
+	require.NoError(t, err)
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	m := NewManager(codec, store, Options{})
+
+	// Test: Untouched new session sets no cookie
+	s, err := m.Get(requestWithCookie(""))
+	require.NoError(t, err)
+	assert.True(t, s.IsNew())
+	assert.Empty(t, save(t, m, s))
+
+	// Test: Values and flashes survive across requests
+	s.Set("user", "alice")
+	s.AddFlash("welcome back")
+	cookiePair := save(t, m, s)
+	require.NotEmpty(t, cookiePair)
+	assert.NotContains(t, cookiePair, "alice")
+	assert.Equal(t, 1, store.Len())
+
+	s, err = m.Get(requestWithCookie(cookiePair))
+	require.NoError(t, err)
+	assert.False(t, s.IsNew())
+	user, ok := s.Get("user")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", user)
+	assert.Equal(t, []string{"welcome back"}, s.Flashes())
+	assert.Empty(t, s.Flashes())
+	save(t, m, s)
+
+	s, err = m.Get(requestWithCookie(cookiePair))
+	require.NoError(t, err)
+	assert.Empty(t, s.Flashes())
+
+	// Test: Destroy removes the session and expires the cookie
+	s.Destroy()
+	assert.Equal(t, "session=", save(t, m, s))
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Forged cookie gets a fresh session
+	s, err = m.Get(requestWithCookie("session=forged.value"))
+	require.NoError(t, err)
+	assert.True(t, s.IsNew())
+}
+
+func TestManagerLoad(t *testing.T) {
+	codec, err := NewSigningCodec(key1)
+	require.NoError(t, err)
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	m := NewManager(codec, store, Options{})
+
+	// Test: Gets after Load share the new visitor's session
+	req, s, err := m.Load(requestWithCookie(""))
+	require.NoError(t, err)
+	again, err := m.Get(req)
+	require.NoError(t, err)
+	assert.Same(t, s, again)
+	_, again, err = m.Load(req)
+	require.NoError(t, err)
+	assert.Same(t, s, again)
+
+	// Test: Middleware does the same for the handler
+	var ids []string
+	handler := m.Middleware(func(w *response.Writer, req *request.Request) {
+		for range 2 {
+			s, err := m.Get(req)
+			if assert.NoError(t, err) {
+				ids = append(ids, s.ID)
+			}
+		}
+	})
+	handler(response.NewWriter(io.Discard), requestWithCookie(""))
+	require.Len(t, ids, 2)
+	assert.NotEmpty(t, ids[0])
+	assert.Equal(t, ids[0], ids[1])
+}
+
+func TestRenewID(t *testing.T) {
+	codec, err := NewSigningCodec(key1)
+	require.NoError(t, err)
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	m := NewManager(codec, store, Options{})
+
+	s, err := m.Get(requestWithCookie(""))
+	require.NoError(t, err)
+	s.Set("user", "alice")
+	oldPair := save(t, m, s)
+
+	// Test: The session moves to a new ID and keeps its values
+	s, err = m.Get(requestWithCookie(oldPair))
+	require.NoError(t, err)
+	oldID := s.ID
+	require.NoError(t, s.RenewID())
+	assert.NotEqual(t, oldID, s.ID)
+	newPair := save(t, m, s)
+	assert.NotEqual(t, oldPair, newPair)
+	assert.Equal(t, 1, store.Len())
+
+	s, err = m.Get(requestWithCookie(newPair))
+	require.NoError(t, err)
+	user, _ := s.Get("user")
+	assert.Equal(t, "alice", user)
+
+	// Test: The old ID no longer resolves
+	s, err = m.Get(requestWithCookie(oldPair))
+	require.NoError(t, err)
+	assert.True(t, s.IsNew())
+}
+
+func TestSaveTooLarge(t *testing.T) {
+	codec, err := NewSigningCodec(key1)
+	require.NoError(t, err)
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	m := NewManager(codec, store, Options{CookieName: strings.Repeat("s", 4096)})
+
+	// Test: Oversized cookies fail before anything is stored
+	s, err := m.Get(requestWithCookie(""))
+	require.NoError(t, err)
+	s.Set("user", "alice")
+	assert.Error(t, m.Save(response.NewWriter(io.Discard), s))
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestManagerCookieOnly(t *testing.T) {
+	codec, err := NewEncryptingCodec(key1)
+	require.NoError(t, err)
+	m := NewManager(codec, nil, Options{CookieName: "sid"})
+
+	// Test: Session data travels encrypted in the cookie
+	s, err := m.Get(requestWithCookie(""))
+	require.NoError(t, err)
+	s.Set("cart", "3 items")
+	cookiePair := save(t, m, s)
+	assert.True(t, strings.HasPrefix(cookiePair, "sid="))
+	assert.NotContains(t, cookiePair, "items")
+
+	s, err = m.Get(requestWithCookie(cookiePair))
+	require.NoError(t, err)
+	cart, _ := s.Get("cart")
+	assert.Equal(t, "3 items", cart)
+
+	// Test: Old key still reads, session is re-encoded with the new one
+	rotatedCodec, err := NewEncryptingCodec(bytes.Repeat([]byte{3}, 32), key1)
+	require.NoError(t, err)
+	rotated := NewManager(rotatedCodec, nil, Options{CookieName: "sid"})
+	s, err = rotated.Get(requestWithCookie(cookiePair))
+	require.NoError(t, err)
+	cart, _ = s.Get("cart")
+	assert.Equal(t, "3 items", cart)
+	newPair := save(t, rotated, s)
+	require.NotEmpty(t, newPair)
+	_, err = codec.Decode("sid", strings.TrimPrefix(newPair, "sid="))
+	assert.Error(t, err)
+}
+
+func TestStores(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	memoryStore := NewMemoryStore(10 * time.Millisecond)
+	defer memoryStore.Close()
+
+	for _, store := range []Store{memoryStore, fileStore} {
+		id, err := newID()
+		require.NoError(t, err)
+
+		// Test: Set and Get
+		require.NoError(t, store.Set(id, Data{Values: map[string]string{"k": "v"}, Expires: time.Now().Add(time.Hour)}))
+		data, ok, err := store.Get(id)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "v", data.Values["k"])
+
+		// Test: Expired sessions are not returned
+		require.NoError(t, store.Set(id, Data{Expires: time.Now().Add(-time.Second)}))
+		_, ok, err = store.Get(id)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// Test: Delete
+		require.NoError(t, store.Set(id, Data{Expires: time.Now().Add(time.Hour)}))
+		require.NoError(t, store.Delete(id))
+		_, ok, err = store.Get(id)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	// Test: Memory store evicts expired sessions in the background
+	id, _ := newID()
+	memoryStore.Set(id, Data{Expires: time.Now().Add(5 * time.Millisecond)})
+	assert.Eventually(t, func() bool { return memoryStore.Len() == 0 }, time.Second, 5*time.Millisecond)
+
+	// Test: File store rejects ids that could escape its directory
+	assert.Error(t, fileStore.Set("../../etc/passwd", Data{}))
+	require.NoError(t, fileStore.Cleanup())
+}
+
+func TestMemoryStoreDefaultInterval(t *testing.T) {
+	// Test: Non-positive intervals don't crash the eviction loop
+	for _, interval := range []time.Duration{0, -time.Second} {
+		store := NewMemoryStore(interval)
+		require.NoError(t, store.Set("id", Data{Expires: time.Now().Add(time.Hour)}))
+		time.Sleep(10 * time.Millisecond)
+		_, ok, err := store.Get("id")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		store.Close()
+	}
+}
@@ -0,0 +1,213 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCleanupInterval = time.Minute
+
+// Data is what a session persists between requests.
+type Data struct {
+	Values  map[string]string `json:"values"`
+	Flashes []string          `json:"flashes,omitempty"`
+	Expires time.Time         `json:"expires"`
+}
+
+func (d Data) expired() bool {
+	return !d.Expires.IsZero() && time.Now().After(d.Expires)
+}
+
+// Store keeps session data on the server, keyed by session ID. Get reports
+// false for unknown and expired sessions.
+type Store interface {
+	Get(id string) (Data, bool, error)
+	Set(id string, data Data) error
+	Delete(id string) error
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Data
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemoryStore returns a Store that evicts expired sessions every
+// cleanupInterval until Close is called. A non-positive cleanupInterval
+// defaults to one minute.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	if cleanupInterval <= 0 {
+		cleanupInterval = defaultCleanupInterval
+	}
+	s := &MemoryStore{
+		sessions: make(map[string]Data),
+		done:     make(chan struct{}),
+	}
+	go s.evictLoop(cleanupInterval)
+	return s
+}
+
+func (s *MemoryStore) Get(id string) (Data, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.sessions[id]
+	if !ok || data.expired() {
+		delete(s.sessions, id)
+		return Data{}, false, nil
+	}
+	return data, true, nil
+}
+
+func (s *MemoryStore) Set(id string, data Data) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = data
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *MemoryStore) Close() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+func (s *MemoryStore) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.evict()
+		}
+	}
+}
+
+func (s *MemoryStore) evict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, data := range s.sessions {
+		if data.expired() {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// FileStore keeps one JSON file per session in a directory.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Get(id string) (Data, bool, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return Data{}, false, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Data{}, false, nil
+	}
+	if err != nil {
+		return Data{}, false, err
+	}
+	var data Data
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return Data{}, false, err
+	}
+	if data.expired() {
+		os.Remove(path)
+		return Data{}, false, nil
+	}
+	return data, true, nil
+}
+
+// Set writes to a temporary file first so readers never see a partial
+// session.
+func (s *FileStore) Set(id string, data Data) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".session-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Cleanup removes the files of expired sessions.
+func (s *FileStore) Cleanup() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, _, err := s.Get(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path only accepts IDs the Manager generates, so a forged ID can't point
+// outside the directory.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", errors.New("sessions: invalid session id")
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
@@ -0,0 +1,194 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting, it is
+	// sent in milliseconds and left out when zero.
+	Retry time.Duration
+}
+
+type Options struct {
+	// HeartbeatInterval is how often a comment is sent to keep proxies from
+	// timing out the stream and to notice clients that went away. Zero
+	// disables heartbeats.
+	HeartbeatInterval time.Duration
+}
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Stream writes events to a response as a chunked text/event-stream.
+// Once created, the response must only be written through the Stream, and
+// Close must be called before the handler returns so the heartbeat stops
+// writing to the connection.
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStream writes the event stream status line and headers to w and
+// starts the heartbeat if one is configured.
+func NewStream(w *response.Writer, req *request.Request, opts Options) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("X-Accel-Buffering", "no")
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:           w,
+		lastEventID: LastEventID(req),
+		done:        make(chan struct{}),
+	}
+	if opts.HeartbeatInterval > 0 {
+		go s.heartbeat(opts.HeartbeatInterval)
+	}
+	go s.watch(req.Context())
+	return s, nil
+}
+
+// LastEventID returns the ID of the last event a reconnecting client saw,
+// or an empty string on its first connection.
+func LastEventID(req *request.Request) string {
+	id, _ := req.Headers.Get("Last-Event-ID")
+	return id
+}
+
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the stream is closed, a write fails or the request's
+// context is cancelled because the client disconnected.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return errors.New("sse: invalid event id")
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return errors.New("sse: invalid event name")
+	}
+
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range splitLines(e.Data) {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Comment sends a line clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Close ends the chunked body and stops the heartbeat.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.stop()
+
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (s *Stream) write(data string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, err := s.w.WriteChunkedBody([]byte(data)); err != nil {
+		s.closed = true
+		s.stop()
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) stop() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// watch closes the stream when ctx is cancelled, so handlers waiting on
+// Done return. Close then only has to let the server end the body.
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		s.stop()
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
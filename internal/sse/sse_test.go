@@ -0,0 +1,130 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+func startServer(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+func get(t *testing.T, addr, extra string) (net.Conn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\n"+extra+"\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return conn, resp
+}
+
+func TestStream(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{})
+		if err != nil {
+			return
+		}
+		s.Send(Event{Data: "resumed after " + s.LastEventID()})
+		s.Send(Event{ID: "7", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second})
+		s.Comment("just a comment")
+		s.Close()
+	})
+
+	// Test: Events are formatted and the stream ends cleanly
+	_, resp := get(t, addr, "Last-Event-ID: 6\r\n")
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "data: resumed after 6\n\n"+
+		"id: 7\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n"+
+		": just a comment\n\n", string(body))
+}
+
+func TestStreamInvalidFields(t *testing.T) {
+	errs := make(chan error, 2)
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{})
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		errs <- s.Send(Event{ID: "1\n2"})
+		errs <- s.Send(Event{Event: "a\rb"})
+	})
+
+	// Test: Newlines in id and event are rejected
+	get(t, addr, "")
+	assert.Error(t, <-errs)
+	assert.Error(t, <-errs)
+}
+
+func TestStreamHeartbeatAndDisconnect(t *testing.T) {
+	done := make(chan struct{})
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{HeartbeatInterval: 10 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		<-s.Done()
+		close(done)
+	})
+
+	// Test: Heartbeat comments are sent
+	conn, resp := get(t, addr, "")
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, ": heartbeat"))
+
+	// Test: Done is closed after the client goes away
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not notice the disconnect")
+	}
+}
+
+func TestStreamClosesOnDisconnect(t *testing.T) {
+	// Test: Done without heartbeats once the client goes away
+	done := make(chan error, 1)
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{})
+		if err != nil {
+			done <- err
+			return
+		}
+		defer s.Close()
+		select {
+		case <-s.Done():
+			done <- s.Send(Event{Data: "late"})
+		case <-time.After(2 * time.Second):
+			done <- nil
+		}
+	})
+	conn, resp := get(t, addr, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	conn.Close()
+	assert.ErrorIs(t, <-done, ErrClosed)
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+// startReceiver runs an OTLP/HTTP receiver on this repo's server and sends
+// every decoded export request to the returned channel.
+func startReceiver(t *testing.T, status response.StatusCode) (string, <-chan otlpRequest) {
+	t.Helper()
+	received := make(chan otlpRequest, 10)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		var export otlpRequest
+		if req.RequestLine.RequestTarget == "/v1/traces" && json.Unmarshal(req.Body, &export) == nil {
+			received <- export
+		}
+		w.WriteStatusLine(status)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	port := s.Addr().(*net.TCPAddr).Port
+	return fmt.Sprintf("http://127.0.0.1:%d/v1/traces", port), received
+}
+
+func TestOTLPExporter(t *testing.T) {
+	endpoint, received := startReceiver(t, response.OK)
+	exporter := NewOTLPExporter(OTLPOptions{Endpoint: endpoint, ServiceName: "checkout"})
+	tracer := NewTracer(exporter, Options{})
+
+	parent := tracer.Start("GET", SpanKindServer, SpanContext{})
+	child := tracer.Start("GET", SpanKindClient, parent.Context())
+	child.SetAttribute("http.response.status_code", 502)
+	child.SetAttribute("url.full", "http://origin/")
+	child.SetStatus(StatusError, "")
+	child.End()
+	parent.End()
+	tracer.Shutdown()
+
+	export := <-received
+	require.Len(t, export.ResourceSpans, 1)
+	rs := export.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "checkout", *rs.Resource.Attributes[0].Value.StringValue)
+	require.Len(t, rs.ScopeSpans, 1)
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	client := spans[0]
+	assert.Equal(t, 3, client.Kind)
+	assert.Equal(t, parent.Context().TraceID.String(), client.TraceID)
+	assert.Equal(t, parent.Context().SpanID.String(), client.ParentSpanID)
+	assert.Equal(t, 2, client.Status.Code)
+	start, err := strconv.ParseInt(client.StartTimeUnixNano, 10, 64)
+	require.NoError(t, err)
+	end, err := strconv.ParseInt(client.EndTimeUnixNano, 10, 64)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, start, end)
+
+	attributes := make(map[string]otlpValue)
+	for _, kv := range client.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "502", *attributes["http.response.status_code"].IntValue)
+	assert.Equal(t, "http://origin/", *attributes["url.full"].StringValue)
+
+	assert.Equal(t, 2, spans[1].Kind)
+	assert.Empty(t, spans[1].ParentSpanID)
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	// Test: Collector errors reach OnError
+	endpoint, _ := startReceiver(t, response.InternalServerError)
+	errs := make(chan error, 1)
+	tracer := NewTracer(NewOTLPExporter(OTLPOptions{Endpoint: endpoint}), Options{OnError: func(err error) { errs <- err }})
+	tracer.Start("GET", SpanKindServer, SpanContext{}).End()
+	tracer.Shutdown()
+	assert.ErrorContains(t, <-errs, "500")
+}
@@ -0,0 +1,194 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	// FlagSampled is the trace-flags bit telling the trace is recorded.
+	FlagSampled byte = 0x01
+
+	maxTracestateMembers = 32
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+func (id SpanID) IsValid() bool   { return id != SpanID{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value. Versions above 00
+// are parsed as 00, ignoring any fields they append.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	if len(value) < 55 {
+		return sc, errors.New("traceparent too short")
+	}
+	version := value[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return sc, fmt.Errorf("invalid traceparent version %q", version)
+	}
+	if version == "00" && len(value) != 55 {
+		return sc, errors.New("traceparent has trailing data")
+	}
+	if len(value) > 55 && value[55] != '-' {
+		return sc, errors.New("traceparent has trailing data")
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, errors.New("malformed traceparent")
+	}
+
+	traceID, spanID, flags := value[3:35], value[36:52], value[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return sc, errors.New("traceparent fields must be lowercase hex")
+	}
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+	var f [1]byte
+	hex.Decode(f[:], []byte(flags))
+	sc.Flags = f[0]
+
+	if !sc.TraceID.IsValid() {
+		return SpanContext{}, errors.New("traceparent has an all zero trace-id")
+	}
+	if !sc.SpanID.IsValid() {
+		return SpanContext{}, errors.New("traceparent has an all zero parent-id")
+	}
+	return sc, nil
+}
+
+// ParseTracestate validates a tracestate header value and returns it with
+// empty members removed.
+func ParseTracestate(value string) (string, error) {
+	var members []string
+	seen := make(map[string]bool)
+	for _, member := range strings.Split(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || !validTracestateKey(key) || !validTracestateValue(val) {
+			return "", fmt.Errorf("invalid tracestate member %q", member)
+		}
+		if seen[key] {
+			return "", fmt.Errorf("duplicate tracestate key %q", key)
+		}
+		seen[key] = true
+		members = append(members, member)
+	}
+	if len(members) > maxTracestateMembers {
+		return "", errors.New("too many tracestate members")
+	}
+	return strings.Join(members, ","), nil
+}
+
+// Extract returns the span context a client sent with req. An invalid
+// tracestate is dropped without invalidating the traceparent.
+func Extract(req *request.Request) (SpanContext, bool) {
+	traceparent, ok := req.Headers.Get(traceparentHeader)
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if tracestate, ok := req.Headers.Get(tracestateHeader); ok {
+		sc.TraceState, _ = ParseTracestate(tracestate)
+	}
+	return sc, true
+}
+
+// Inject sets the traceparent and tracestate headers for sc on h.
+func Inject(h headers.Headers, sc SpanContext) {
+	h.Override(traceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Override(tracestateHeader, sc.TraceState)
+	} else {
+		h.Delete(tracestateHeader)
+	}
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range []byte(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validTracestateKey accepts simple keys like "vendor" and multi-tenant
+// keys like "tenant@vendor".
+func validTracestateKey(key string) bool {
+	tenant, system, multiTenant := strings.Cut(key, "@")
+	if !multiTenant {
+		return len(key) <= 256 && validKeyPart(key, true)
+	}
+	return len(tenant) <= 241 && len(system) <= 14 && validKeyPart(tenant, false) && validKeyPart(system, true)
+}
+
+func validKeyPart(s string, mustStartWithLetter bool) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range []byte(s) {
+		lower := c >= 'a' && c <= 'z'
+		digit := c >= '0' && c <= '9'
+		if i == 0 && mustStartWithLetter && !lower {
+			return false
+		}
+		if !lower && !digit && c != '_' && c != '-' && c != '*' && c != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+func validTracestateValue(value string) bool {
+	if value == "" || len(value) > 256 || value[len(value)-1] == ' ' {
+		return false
+	}
+	for _, c := range []byte(value) {
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
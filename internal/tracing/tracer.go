@@ -0,0 +1,306 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+const (
+	defaultBatchSize     = 64
+	defaultFlushInterval = 5 * time.Second
+	queueSize            = 2048
+)
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+// SpanData is a finished span as handed to exporters.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	// Attributes hold string, bool, int, int64 or float64 values.
+	Attributes    map[string]any
+	Status        StatusCode
+	StatusMessage string
+}
+
+// Exporter sends finished spans somewhere. Export is called from a single
+// goroutine at a time.
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+type Options struct {
+	// BatchSize is how many spans are exported at once, 64 by default.
+	BatchSize int
+	// FlushInterval exports a partial batch after this long, 5 seconds by
+	// default.
+	FlushInterval time.Duration
+	// OnError is called when an export fails, by default the error is
+	// logged.
+	OnError func(error)
+}
+
+// Tracer creates spans and exports the sampled ones in the background.
+// Shutdown must be called to export the spans still queued.
+type Tracer struct {
+	exporter Exporter
+	opts     Options
+
+	queue    chan SpanData
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewTracer(exporter Exporter, opts Options) *Tracer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(err error) { log.Printf("tracing: export failed: %v", err) }
+	}
+	t := &Tracer{
+		exporter: exporter,
+		opts:     opts,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+// Start begins a span. A valid parent continues its trace and sampling
+// decision, otherwise a new sampled trace is started.
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	sc := SpanContext{SpanID: newSpanID(), Flags: FlagSampled}
+	var parentID SpanID
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		sc.TraceState = parent.TraceState
+		parentID = parent.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+	}
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			Context:      sc,
+			ParentSpanID: parentID,
+			Start:        time.Now(),
+			Attributes:   make(map[string]any),
+		},
+	}
+}
+
+// Shutdown exports the queued spans and stops the tracer. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Shutdown() {
+	t.stopOnce.Do(func() { close(t.done) })
+	t.wg.Wait()
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	select {
+	case t.queue <- data:
+	default:
+		// drop rather than block the request when the exporter can't keep up
+	}
+}
+
+func (t *Tracer) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, t.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			t.opts.OnError(err)
+		}
+		batch = make([]SpanData, 0, t.opts.BatchSize)
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Span is an operation being timed. Its methods are safe to call from
+// several goroutines, and do nothing once the span has ended.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span's context, or the zero SpanContext for a nil
+// span so SpanFromRequest can be passed to Start unchecked.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attributes[key] = value
+	}
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Status = code
+		s.data.StatusMessage = message
+	}
+}
+
+// End records the end time and queues the span for export if it is
+// sampled.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled() {
+		s.tracer.enqueue(data)
+	}
+}
+
+// Trace returns a handler that runs next inside a server span, continuing
+// the trace from the request's traceparent header if it has a valid one.
+func Trace(t *Tracer, next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		parent, _ := Extract(req)
+		span := t.Start(req.RequestLine.Method, SpanKindServer, parent)
+		span.SetAttribute("http.request.method", req.RequestLine.Method)
+		span.SetAttribute("url.path", req.RequestLine.RequestTarget)
+		span.SetAttribute("network.protocol.version", req.RequestLine.HttpVersion)
+		if userAgent, ok := req.Headers.Get("User-Agent"); ok {
+			span.SetAttribute("user_agent.original", userAgent)
+		}
+
+		next(w, req.WithContext(ContextWithSpan(req.Context(), span)))
+
+		status := w.StatusCode()
+		span.SetAttribute("http.response.status_code", int(status))
+		span.SetAttribute("http.response.body.size", w.BodyBytes())
+		if status >= 500 || status == 0 {
+			span.SetStatus(StatusError, "")
+		}
+		span.End()
+	}
+}
+
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span stored in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanFromRequest returns the server span Trace started for req, or nil
+// outside of a traced handler.
+func SpanFromRequest(req *request.Request) *Span {
+	return SpanFromContext(req.Context())
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
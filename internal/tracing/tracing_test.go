@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+// collector keeps exported spans in memory.
+type collector struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (c *collector) Export(spans []SpanData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+func newRequest(t *testing.T, headers string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET /items HTTP/1.1\r\nHost: localhost\r\n" + headers + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestParseTraceparent(t *testing.T) {
+	// Test: Valid version 00
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// Test: Future versions may append fields
+	_, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-holds")
+	assert.NoError(t, err)
+
+	invalid := []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	}
+	for _, value := range invalid {
+		_, err := ParseTraceparent(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseTracestate(t *testing.T) {
+	// Test: Empty members are dropped
+	ts, err := ParseTracestate("rojo=00f067aa0ba902b7, ,congo=t61rcWkgMzE, tenant@vendor=x")
+	require.NoError(t, err)
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE,tenant@vendor=x", ts)
+
+	invalid := []string{"Rojo=1", "rojo", "rojo=a,rojo=b", "rojo=a=b", "rojo=tab\tinside", "@vendor=x"}
+	for _, value := range invalid {
+		_, err := ParseTracestate(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: At most 32 members
+	members := make([]string, 33)
+	for i := range members {
+		members[i] = fmt.Sprintf("key%d=v", i)
+	}
+	_, err = ParseTracestate(strings.Join(members, ","))
+	assert.Error(t, err)
+}
+
+func TestTrace(t *testing.T) {
+	c := &collector{}
+	tracer := NewTracer(c, Options{})
+
+	var inner *Span
+	handler := Trace(tracer, func(w *response.Writer, req *request.Request) {
+		inner = SpanFromRequest(req)
+		body := "ok"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+
+	// Test: Continues the client's trace
+	req := newRequest(t, "traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\ntracestate: rojo=1\r\n")
+	handler(response.NewWriter(&bytes.Buffer{}), req)
+	require.NotNil(t, inner)
+	continued := inner.Context()
+	assert.Nil(t, SpanFromRequest(req))
+
+	// Test: Unsampled traces propagate but aren't exported
+	handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, "traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00\r\n"))
+
+	// Test: Invalid traceparent starts a new trace
+	handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, "traceparent: garbage\r\n"))
+
+	tracer.Shutdown()
+	require.Len(t, c.spans, 2)
+
+	span := c.spans[0]
+	assert.Equal(t, "GET", span.Name)
+	assert.Equal(t, SpanKindServer, span.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID.String())
+	assert.Equal(t, "rojo=1", span.Context.TraceState)
+	assert.Equal(t, continued, span.Context)
+	assert.Equal(t, 200, span.Attributes["http.response.status_code"])
+	assert.Equal(t, "/items", span.Attributes["url.path"])
+	assert.Equal(t, StatusUnset, span.Status)
+
+	root := c.spans[1]
+	assert.NotEqual(t, span.Context.TraceID, root.Context.TraceID)
+	assert.False(t, root.ParentSpanID.IsValid())
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&buf), Options{})
+	span := tracer.Start("work", SpanKindInternal, SpanContext{})
+	span.SetAttribute("items", 3)
+	span.SetStatus(StatusError, "boom")
+	span.End()
+	tracer.Shutdown()
+
+	var out map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, "work", out["name"])
+	assert.Equal(t, "internal", out["kind"])
+	assert.Equal(t, span.Context().TraceID.String(), out["trace_id"])
+	assert.Equal(t, "error", out["status"])
+	assert.Equal(t, "boom", out["status_message"])
+	assert.EqualValues(t, 3, out["attributes"].(map[string]any)["items"])
+}
@@ -0,0 +1,475 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	maxControlPayload = 125
+	closeTimeout      = 5 * time.Second
+)
+
+// CloseError is returned by ReadMessage once the close handshake started,
+// it carries the status code and reason the peer sent.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Reason)
+}
+
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a WebSocket connection. One goroutine may call ReadMessage while
+// others call WriteMessage, WritePing and Close; writes are serialized and
+// concurrent ReadMessage calls wait for each other. SetPongHandler has to be
+// called before reading starts.
+type Conn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isServer bool
+
+	subprotocol  string
+	compress     bool
+	readLimit    int64
+	maxFrameSize int
+
+	// readMu is held by whoever reads frames from br, ReadMessage or Close.
+	readMu sync.Mutex
+	// closeRead is closed once a close frame was read or reading failed,
+	// which is what Close waits for while a ReadMessage is running.
+	closeRead     chan struct{}
+	closeReadOnce sync.Once
+
+	writeMu   sync.Mutex
+	closeSent bool
+
+	pongHandler func(data []byte)
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  opcode
+	payload []byte
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, isServer bool, opts Options) *Conn {
+	readLimit := opts.ReadLimit
+	if readLimit <= 0 {
+		readLimit = defaultReadLimit
+	}
+	maxFrameSize := opts.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = defaultMaxFrameSize
+	}
+	return &Conn{
+		conn:         conn,
+		br:           br,
+		isServer:     isServer,
+		readLimit:    readLimit,
+		maxFrameSize: maxFrameSize,
+		closeRead:    make(chan struct{}),
+	}
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// SetPongHandler registers a callback for pong frames, e.g. to extend a read
+// deadline when keepalive pings are answered.
+func (c *Conn) SetPongHandler(handler func(data []byte)) {
+	c.pongHandler = handler
+}
+
+// ReadMessage returns the next data message, reassembling fragments and
+// answering pings that arrive in between. When the peer starts the close
+// handshake it is answered and a *CloseError is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	var (
+		messageType MessageType
+		compressed  bool
+		message     []byte
+		inMessage   bool
+	)
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, f.payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				c.pongHandler(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "new message before previous one finished")
+			}
+			inMessage = true
+			messageType = MessageType(f.opcode)
+			compressed = f.rsv1
+		case opContinuation:
+			if !inMessage {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		}
+
+		if int64(len(message)+len(f.payload)) > c.readLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = decompress(message, c.readLimit)
+			if errors.Is(err, errTooBig) {
+				return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+			}
+			if err != nil {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid compressed payload")
+			}
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+		return messageType, message, nil
+	}
+}
+
+// WriteMessage sends data as a single message, fragmented into frames of
+// at most MaxFrameSize bytes.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: invalid message type")
+	}
+	compressed := c.compress && len(data) > 0
+	if compressed {
+		var err error
+		data, err = compressMessage(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	op := opcode(messageType)
+	for first := true; first || len(data) > 0; first = false {
+		n := min(len(data), c.maxFrameSize)
+		f := frame{
+			fin:     n == len(data),
+			rsv1:    compressed && first,
+			opcode:  op,
+			payload: data[:n],
+		}
+		if err := c.writeFrame(f); err != nil {
+			return err
+		}
+		data = data[n:]
+		op = opContinuation
+	}
+	return nil
+}
+
+func (c *Conn) WritePing(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// Close starts the close handshake with code and reason, waits briefly for
+// the peer to answer and closes the connection. If a ReadMessage is running
+// it reads the answer and Close waits for that instead.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeControl(opClose, closePayload(code, reason))
+	if err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		if c.readMu.TryLock() {
+			for !c.closeReceived() {
+				c.readFrame()
+			}
+			c.readMu.Unlock()
+		} else {
+			// the reader may be between frames, so don't rely on it
+			// reading again
+			timer := time.NewTimer(closeTimeout)
+			select {
+			case <-c.closeRead:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}
+	if errors.Is(err, ErrClosed) {
+		err = nil
+	}
+	closeErr := c.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// closeReceived reports whether the peer's close frame was read, or
+// reading failed so it never will be.
+func (c *Conn) closeReceived() bool {
+	select {
+	case <-c.closeRead:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close payload")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, "invalid close reason")
+		}
+	}
+
+	echo := closePayload(closeErr.Code, "")
+	if closeErr.Code == CloseNoStatus {
+		echo = nil
+	}
+	c.writeControl(opClose, echo)
+	return closeErr
+}
+
+// fail sends a close frame for a protocol violation by the peer and returns
+// the matching error.
+func (c *Conn) fail(code int, reason string) error {
+	c.writeControl(opClose, closePayload(code, reason))
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *Conn) writeControl(op opcode, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return errors.New("websocket: control frame payload too big")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if op == opClose {
+		c.closeSent = true
+	}
+	return c.writeFrame(frame{fin: true, opcode: op, payload: payload})
+}
+
+// readFrame must be called with readMu held.
+func (c *Conn) readFrame() (frame, error) {
+	f, err := c.nextFrame()
+	if err != nil || f.opcode == opClose {
+		c.closeReadOnce.Do(func() { close(c.closeRead) })
+	}
+	return f, err
+}
+
+func (c *Conn) nextFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: opcode(header[0] & 0x0F),
+	}
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	if header[0]&0x30 != 0 {
+		return frame{}, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if masked != c.isServer {
+		return frame{}, c.fail(CloseProtocolError, "invalid frame masking")
+	}
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+		if f.rsv1 && (!c.compress || f.opcode == opContinuation) {
+			return frame{}, c.fail(CloseProtocolError, "unexpected compressed frame")
+		}
+	case opClose, opPing, opPong:
+		if !f.fin || f.rsv1 || length > maxControlPayload {
+			return frame{}, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	default:
+		return frame{}, c.fail(CloseProtocolError, "unknown opcode")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(c.readLimit) {
+		return frame{}, c.fail(CloseMessageTooBig, "frame too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame must be called with writeMu held.
+func (c *Conn) writeFrame(f frame) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+
+	b0 := byte(f.opcode)
+	if f.fin {
+		b0 |= 0x80
+	}
+	if f.rsv1 {
+		b0 |= 0x40
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if !c.isServer {
+		b1 = 0x80
+	}
+	length := len(f.payload)
+	switch {
+	case length <= 125:
+		buf = append(buf, b1|byte(length))
+	case length <= 0xFFFF:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(length))
+	}
+
+	if c.isServer {
+		buf = append(buf, f.payload...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, f.payload...)
+		maskBytes(mask, buf[start:])
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != 1005 && code != 1006
+	}
+	return false
+}
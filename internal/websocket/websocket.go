@@ -0,0 +1,176 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/headers"
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+)
+
+const (
+	acceptGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	supportedVersion = "13"
+
+	defaultReadLimit    = 16 << 20
+	defaultMaxFrameSize = 64 << 10
+)
+
+type Options struct {
+	// Subprotocols in order of preference, the first one the client also
+	// offers is selected.
+	Subprotocols []string
+	// EnableCompression negotiates permessage-deflate when the client offers it.
+	EnableCompression bool
+	// ReadLimit caps the size of a reassembled message, defaults to 16 MiB.
+	ReadLimit int64
+	// MaxFrameSize is the payload size outgoing messages are fragmented
+	// at, defaults to 64 KiB.
+	MaxFrameSize int
+	// CheckOrigin rejects the handshake when it returns false, by default
+	// every origin is accepted.
+	CheckOrigin func(req *request.Request) bool
+}
+
+// Upgrade validates the opening handshake in req, writes the 101 response
+// through w and returns the connection for exchanging messages.
+// On a bad handshake it writes an error response and returns an error.
+// The connection is hijacked from the server, so the caller must Close it.
+func Upgrade(w *response.Writer, req *request.Request, opts Options) (*Conn, error) {
+	key, err := validateHandshake(req)
+	if err != nil {
+		if errors.Is(err, errUnsupportedVersion) {
+			h := headers.NewHeaders()
+			h.Set("Sec-WebSocket-Version", supportedVersion)
+			writeError(w, response.UpgradeRequired, err, h)
+		} else {
+			writeError(w, response.BadRequest, err, nil)
+		}
+		return nil, err
+	}
+	if opts.CheckOrigin != nil && !opts.CheckOrigin(req) {
+		err := errors.New("websocket: origin not allowed")
+		writeError(w, response.Forbidden, err, nil)
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+
+	subprotocol := selectSubprotocol(req, opts.Subprotocols)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := opts.EnableCompression && offersDeflate(req)
+	if compress {
+		h.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	if err := w.WriteStatusLine(response.SwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), netConn))
+	c := newConn(netConn, br, true, opts)
+	c.subprotocol = subprotocol
+	c.compress = compress
+	return c, nil
+}
+
+var errUnsupportedVersion = errors.New("websocket: unsupported version")
+
+func validateHandshake(req *request.Request) (string, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", errors.New("websocket: method is not GET")
+	}
+	if req.RequestLine.HttpVersion != "1.1" {
+		return "", errors.New("websocket: HTTP/1.1 is required")
+	}
+	if !headers.HasToken(req.Headers, "Connection", "upgrade") {
+		return "", errors.New("websocket: missing Connection: Upgrade")
+	}
+	if !headers.HasToken(req.Headers, "Upgrade", "websocket") {
+		return "", errors.New("websocket: missing Upgrade: websocket")
+	}
+	if v, _ := req.Headers.Get("Sec-WebSocket-Version"); v != supportedVersion {
+		return "", errUnsupportedVersion
+	}
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", errors.New("websocket: invalid Sec-WebSocket-Key")
+	}
+	return key, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func selectSubprotocol(req *request.Request, supported []string) string {
+	for _, protocol := range supported {
+		if headers.HasToken(req.Headers, "Sec-WebSocket-Protocol", protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// offersDeflate reports whether the client offered permessage-deflate with
+// parameters we can honor. compress/flate always uses a 15 bit window, so
+// offers limiting the server window are declined.
+func offersDeflate(req *request.Request) bool {
+	extensions, ok := req.Headers.Get("Sec-WebSocket-Extensions")
+	if !ok {
+		return false
+	}
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		acceptable := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				acceptable = acceptable && strings.Trim(value, `"`) == "15"
+			default:
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, err error, extra headers.Headers) {
+	body := fmt.Sprintf("%s\n", err)
+	h := response.GetDefaultHeaders(len(body))
+	for k, v := range extra {
+		h.Override(k, v)
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body))
+}
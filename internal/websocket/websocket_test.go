@@ -0,0 +1,244 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dmytrochumakov/httpfromtcp/internal/request"
+	"github.com/dmytrochumakov/httpfromtcp/internal/response"
+	"github.com/dmytrochumakov/httpfromtcp/internal/server"
+)
+
+func echoHandler(opts Options) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, opts)
+		if err != nil {
+			return
+		}
+		defer c.Close(CloseNormalClosure, "")
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func startEcho(t *testing.T, opts Options) string {
+	t.Helper()
+	s, err := server.Serve(0, echoHandler(opts))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String()
+}
+
+// dial performs the client side of the opening handshake with extra header
+// lines and returns the response and, on a 101, a client Conn.
+func dial(t *testing.T, addr, extra string) (*http.Response, *Conn) {
+	t.Helper()
+	netConn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { netConn.Close() })
+	netConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.WriteString(netConn, "GET /ws HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		extra+
+		"\r\n")
+	require.NoError(t, err)
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+	c := newConn(netConn, br, false, Options{MaxFrameSize: 16})
+	c.compress = strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	return resp, c
+}
+
+func TestUpgrade(t *testing.T) {
+	addr := startEcho(t, Options{Subprotocols: []string{"chat", "superchat"}})
+
+	// Test: Handshake accept key and subprotocol
+	resp, c := dial(t, addr, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: superchat, chat\r\n")
+	require.NotNil(t, c)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "chat", resp.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	// Test: Text and fragmented binary messages echo back
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("hello")))
+	mt, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, mt)
+	assert.Equal(t, "hello", string(msg))
+
+	payload := bytes.Repeat([]byte{0, 1, 2, 3}, 100)
+	require.NoError(t, c.WriteMessage(BinaryMessage, payload))
+	mt, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, mt)
+	assert.Equal(t, payload, msg)
+
+	// Test: Ping is answered with pong
+	pong := make(chan []byte, 1)
+	c.SetPongHandler(func(data []byte) { pong <- data })
+	require.NoError(t, c.WritePing([]byte("are you there")))
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("after ping")))
+	_, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "after ping", string(msg))
+	assert.Equal(t, "are you there", string(<-pong))
+
+	// Test: Close handshake
+	require.NoError(t, c.Close(CloseNormalClosure, "bye"))
+}
+
+func TestUpgradeCompression(t *testing.T) {
+	addr := startEcho(t, Options{EnableCompression: true})
+
+	// Test: permessage-deflate negotiated and messages round trip
+	resp, c := dial(t, addr, "Sec-WebSocket-Version: 13\r\nSec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	require.NotNil(t, c)
+	assert.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+	text := strings.Repeat("compress me please ", 50)
+	for range 2 {
+		require.NoError(t, c.WriteMessage(TextMessage, []byte(text)))
+		_, msg, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, text, string(msg))
+	}
+	require.NoError(t, c.Close(CloseNormalClosure, ""))
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	addr := startEcho(t, Options{})
+
+	// Test: Missing version
+	resp, c := dial(t, addr, "")
+	assert.Nil(t, c)
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+	assert.Equal(t, "13", resp.Header.Get("Sec-WebSocket-Version"))
+
+	// Test: Unsupported version
+	resp, c = dial(t, addr, "Sec-WebSocket-Version: 8\r\n")
+	assert.Nil(t, c)
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	addr := startEcho(t, Options{})
+
+	// Test: Unmasked client frame is rejected with 1002
+	_, c := dial(t, addr, "Sec-WebSocket-Version: 13\r\n")
+	require.NotNil(t, c)
+	_, err := c.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+	require.NoError(t, err)
+	_, _, err = c.ReadMessage()
+	assertCloseCode(t, err, CloseProtocolError)
+
+	// Test: Invalid UTF-8 text is rejected with 1007
+	_, c = dial(t, addr, "Sec-WebSocket-Version: 13\r\n")
+	require.NotNil(t, c)
+	require.NoError(t, c.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+	_, _, err = c.ReadMessage()
+	assertCloseCode(t, err, CloseInvalidPayload)
+
+	// Test: Continuation without a message is rejected with 1002
+	_, c = dial(t, addr, "Sec-WebSocket-Version: 13\r\n")
+	require.NotNil(t, c)
+	c.writeMu.Lock()
+	require.NoError(t, c.writeFrame(frame{fin: true, opcode: opContinuation, payload: []byte("x")}))
+	c.writeMu.Unlock()
+	_, _, err = c.ReadMessage()
+	assertCloseCode(t, err, CloseProtocolError)
+}
+
+func TestCloseDuringRead(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+	client := newConn(clientSide, bufio.NewReader(clientSide), false, Options{})
+	peer := newConn(serverSide, bufio.NewReader(serverSide), true, Options{})
+	go func() {
+		for {
+			if _, _, err := peer.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := client.ReadMessage()
+		readErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Test: Close lets the blocked reader consume the peer's answer
+	start := time.Now()
+	assert.NoError(t, client.Close(CloseNormalClosure, "bye"))
+	assert.Less(t, time.Since(start), closeTimeout)
+	select {
+	case err := <-readErr:
+		assertCloseCode(t, err, CloseNormalClosure)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage did not return")
+	}
+}
+
+func assertCloseCode(t *testing.T, err error, code int) {
+	t.Helper()
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr), "expected close error, got %v", err)
+	assert.Equal(t, code, closeErr.Code)
+}
+
+func TestClosePayload(t *testing.T) {
+	payload := closePayload(CloseGoingAway, "restart")
+	assert.Equal(t, uint16(CloseGoingAway), binary.BigEndian.Uint16(payload))
+	assert.Equal(t, "restart", string(payload[2:]))
+	assert.True(t, validCloseCode(CloseNormalClosure))
+	assert.True(t, validCloseCode(4000))
+	assert.False(t, validCloseCode(CloseNoStatus))
+	assert.False(t, validCloseCode(999))
+}
+
+func TestOffersDeflate(t *testing.T) {
+	tests := map[string]bool{
+		"permessage-deflate":                                                       true,
+		"permessage-deflate; client_max_window_bits":                               true,
+		"permessage-deflate; server_max_window_bits=15":                            true,
+		"permessage-deflate; server_max_window_bits=10":                            false,
+		"permessage-deflate; unknown; server_max_window_bits=15":                   false,
+		"permessage-deflate; server_max_window_bits=10; server_max_window_bits=15": false,
+		"permessage-deflate; unknown, permessage-deflate":                          true,
+		"x-webkit-deflate-frame":                                                   false,
+	}
+	for extensions, want := range tests {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nSec-WebSocket-Extensions: " + extensions + "\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, want, offersDeflate(req), extensions)
+	}
+}